@@ -2,20 +2,188 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
 )
 
 type Config struct {
-	SlackAPIToken       string   `json:"slackApiToken"`
-	TimelineChannelID   string   `json:"timelineChannelID"`
-	BlackListChannelIDs []string `json:"blackListChannelIDs"`
-	Sentry              sentry   `json:"sentry"`
+	SlackAPIToken         string     `json:"slackApiToken"`
+	Mode                  string     `json:"mode"`
+	TimelineChannelID     string     `json:"timelineChannelID"`
+	DB                    string     `json:"db"`
+	Retention             retention  `json:"retention"`
+	Routes                []route    `json:"routes"`
+	BlackListChannelIDs   []string   `json:"blackListChannelIDs"`
+	WhiteListChannelIDs   []string   `json:"whiteListChannelIDs"`
+	WhiteListChannelNames []string   `json:"whiteListChannelNames"`
+	ThreadReplies         string     `json:"threadReplies"`
+	Reactions             reactions  `json:"reactions"`
+	Renderer              string     `json:"renderer"`
+	Template              string     `json:"template"`
+	Filters               []filter   `json:"filters"`
+	Debug                 bool       `json:"debug"`
+	Sentry                sentry     `json:"sentry"`
+	UserCache             userCache  `json:"userCache"`
+	UsersPageSize         int        `json:"usersPageSize"`
+	UserNameField         string     `json:"userNameField"`
+	Reconnect             reconnect  `json:"reconnect"`
+	Events                events     `json:"events"`
+	SocketMode            socketMode `json:"socketMode"`
+}
+
+type route struct {
+	ChannelIDs   []string `json:"channelIDs"`
+	ChannelNames []string `json:"channelNames"`
+	Default      bool     `json:"default"`
+	Timelines    []string `json:"timelines"`
+	Template     string   `json:"template"`
+}
+
+// TimelineRoutes returns the routes to timeline channels.
+// All messages are posted to TimelineChannelID when no route is configured.
+func (c Config) TimelineRoutes() []timeline.Route {
+	if len(c.Routes) == 0 {
+		return []timeline.Route{
+			{Default: true, Timelines: []string{c.TimelineChannelID}},
+		}
+	}
+	rs := []timeline.Route{}
+	for _, r := range c.Routes {
+		rs = append(rs, timeline.Route{
+			ChannelIDs:   r.ChannelIDs,
+			ChannelNames: r.ChannelNames,
+			Default:      r.Default,
+			Timelines:    r.Timelines,
+		})
+	}
+	return rs
+}
+
+// DBURL returns the URL of the db to open, which is flagValue if it is given.
+func (c Config) DBURL(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if c.DB != "" {
+		return c.DB
+	}
+	return "db"
+}
+
+type filter struct {
+	Type             string   `json:"type"`
+	Include          []string `json:"include"`
+	Exclude          []string `json:"exclude"`
+	Min              int      `json:"min"`
+	Max              int      `json:"max"`
+	DenyUserIDs      []string `json:"denyUserIDs"`
+	ExcludeMimeTypes []string `json:"excludeMimeTypes"`
+	AllowBotIDs      []string `json:"allowBotIDs"`
+	DenyBotIDs       []string `json:"denyBotIDs"`
+	AllowAllBots     bool     `json:"allowAllBots"`
+}
+
+// MessageFilters returns the filters in the order of the config.
+// Messages of bots are rejected first if no bot filter is configured.
+func (c Config) MessageFilters() ([]timeline.MessageFilter, error) {
+	fs := []timeline.MessageFilter{}
+	if !c.hasFilter("bot") {
+		fs = append(fs, timeline.BotFilter{})
+	}
+	for _, f := range c.Filters {
+		switch f.Type {
+		case "text":
+			t, e := timeline.NewTextFilter(f.Include, f.Exclude)
+			if e != nil {
+				return nil, e
+			}
+			fs = append(fs, t)
+		case "length":
+			fs = append(fs, timeline.LengthFilter{Min: f.Min, Max: f.Max})
+		case "user":
+			fs = append(fs, timeline.UserFilter{DenyUserIDs: f.DenyUserIDs})
+		case "bot":
+			fs = append(fs, timeline.BotFilter{AllowBotIDs: f.AllowBotIDs, DenyBotIDs: f.DenyBotIDs, AllowAll: f.AllowAllBots})
+		case "file":
+			fs = append(fs, timeline.FileFilter{ExcludeMimeTypes: f.ExcludeMimeTypes})
+		default:
+			return nil, fmt.Errorf("unknown filter type: %s", f.Type)
+		}
+	}
+	return fs, nil
+}
+
+func (c Config) hasFilter(filterType string) bool {
+	for _, f := range c.Filters {
+		if f.Type == filterType {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteTemplates returns the templates of the routes in the same order as TimelineRoutes.
+func (c Config) RouteTemplates() []string {
+	ts := []string{}
+	for _, r := range c.Routes {
+		ts = append(ts, r.Template)
+	}
+	return ts
+}
+
+// CheckTemplates returns an error if templates are set but the renderer does not use them.
+func (c Config) CheckTemplates() error {
+	if c.Renderer == "template" {
+		return nil
+	}
+	if c.Template != "" {
+		return fmt.Errorf("template is set but renderer is %q, not \"template\"", c.Renderer)
+	}
+	for i, r := range c.Routes {
+		if r.Template != "" {
+			return fmt.Errorf("template of routes[%d] is set but renderer is %q, not \"template\"", i, c.Renderer)
+		}
+	}
+	return nil
+}
+
+type retention struct {
+	Days               int `json:"days"`
+	PruneIntervalHours int `json:"pruneIntervalHours"`
+}
+
+type reactions struct {
+	Mirror    bool `json:"mirror"`
+	Aggregate bool `json:"aggregate"`
 }
 
 type sentry struct {
 	DSN *string `json:"dsn"`
 }
 
+type userCache struct {
+	Persist  bool `json:"persist"`
+	TTLHours int  `json:"ttlHours"`
+}
+
+type reconnect struct {
+	MaxRetries             int `json:"maxRetries"`
+	InitialIntervalSeconds int `json:"initialIntervalSeconds"`
+	MaxIntervalSeconds     int `json:"maxIntervalSeconds"`
+}
+
+type events struct {
+	SigningSecret string `json:"signingSecret"`
+	ListenAddress string `json:"listenAddress"`
+	Path          string `json:"path"`
+}
+
+type socketMode struct {
+	AppToken string `json:"appToken"`
+}
+
 func ReadConfig(path string) (*Config, error) {
 	result := Config{}
 	file, openErr := os.Open(path)
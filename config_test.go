@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+func TestCheckTemplates(t *testing.T) {
+	assert.NoError(t, Config{}.CheckTemplates())
+	assert.NoError(t, Config{Renderer: "template", Template: "{{.Text}}"}.CheckTemplates())
+	assert.Error(t, Config{Template: "{{.Text}}"}.CheckTemplates())
+	assert.Error(t, Config{Renderer: "blocks", Routes: []route{{Template: "{{.Text}}"}}}.CheckTemplates())
+}
+
+func TestMessageFiltersRejectBotsByDefault(t *testing.T) {
+	bot := &timeline.Message{BotID: "BCI"}
+	fs, e := Config{}.MessageFilters()
+	if assert.NoError(t, e) && assert.Len(t, fs, 1) {
+		assert.NotEqual(t, "", fs[0].Reject(bot))
+	}
+
+	fs, e = Config{Filters: []filter{{Type: "bot", AllowAllBots: true}}}.MessageFilters()
+	if assert.NoError(t, e) && assert.Len(t, fs, 1) {
+		assert.Equal(t, "", fs[0].Reject(bot))
+	}
+
+	fs, e = Config{Filters: []filter{{Type: "bot", AllowBotIDs: []string{"BCI"}}}}.MessageFilters()
+	if assert.NoError(t, e) && assert.Len(t, fs, 1) {
+		assert.Equal(t, "", fs[0].Reject(bot))
+		assert.NotEqual(t, "", fs[0].Reject(&timeline.Message{BotID: "BOTHER"}))
+	}
+}
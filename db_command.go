@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ara-ta3/slack-timeline/store"
+)
+
+var dbCommandUsage = `usage: slacktimeline [-c config.json] [-db path] db <command>
+
+commands:
+  ls [-channel C] [-since time] [-until time]  list messages posted to the timeline
+  get <channel> <ts>                           show the mappings of the message
+  rm <channel> <ts>                            forget the message, which is kept in the timeline
+  export                                       write all mappings to stdout as JSON Lines
+  import                                       read mappings from stdin as JSON Lines
+  stats                                        show the summary of the db
+  migrate                                      convert the mappings written by older versions
+
+ls, get, export and stats do not change the db. rm and import migrate it first.
+
+channel and ts are of either the original message or the post in the timeline.
+time is RFC3339 like 2006-01-02T15:04:05Z or a date like 2006-01-02.
+`
+
+// runDBCommand runs the subcommand of db against the db of url and returns the exit code.
+// It fails if SlackTimeline is running with the db or the db does not exist.
+func runDBCommand(url string, args []string, in io.Reader, out, errOut io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(errOut, dbCommandUsage)
+		return 2
+	}
+	backend, e := store.OpenExisting(url)
+	if e == store.ErrLocked {
+		fmt.Fprintf(errOut, "%s is in use. stop SlackTimeline before running db commands.\n", url)
+		return 1
+	}
+	if e != nil {
+		fmt.Fprintf(errOut, "%+v\n", e)
+		return 1
+	}
+	defer backend.Close()
+	// the commands which only read the db do not migrate it, and see mappings of older versions as they are
+	reader := store.NewMappingReader(backend)
+
+	switch args[0] {
+	case "ls":
+		e = listMappings(reader, args[1:], out, errOut)
+	case "get":
+		e = getMappings(reader, args[1:], out)
+	case "rm":
+		e = migrateAnd(backend, out, func(db store.MappingStore) error {
+			return removeMappings(db, args[1:], out)
+		})
+	case "export":
+		_, e = store.Export(reader, out)
+	case "import":
+		e = migrateAnd(backend, out, func(db store.MappingStore) error {
+			n, e := store.Import(db, in)
+			fmt.Fprintf(out, "imported %d\n", n)
+			return e
+		})
+	case "migrate":
+		var n int
+		n, e = store.Migrate(backend)
+		fmt.Fprintf(out, "migrated %d\n", n)
+	case "stats":
+		e = printStats(reader, out)
+	default:
+		fmt.Fprint(errOut, dbCommandUsage)
+		return 2
+	}
+	if e != nil {
+		fmt.Fprintf(errOut, "%+v\n", e)
+		return 1
+	}
+	return 0
+}
+
+// migrateAnd migrates the mappings of older versions before f changes the db,
+// so that f sees all of them in the mapping store.
+func migrateAnd(backend store.Backend, out io.Writer, f func(db store.MappingStore) error) error {
+	n, e := store.Migrate(backend)
+	if e != nil {
+		return e
+	}
+	if n > 0 {
+		fmt.Fprintf(out, "migrated %d\n", n)
+	}
+	return f(store.NewMappingStore(backend))
+}
+
+func listMappings(db store.MappingReader, args []string, out, errOut io.Writer) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	channelID := flags.String("channel", "", "channel ID of the original messages or the timeline")
+	since := flags.String("since", "", "list messages posted to the timeline at or after this time")
+	until := flags.String("until", "", "list messages posted to the timeline before this time")
+	if e := flags.Parse(args); e != nil {
+		return e
+	}
+	f := store.MappingFilter{ChannelID: *channelID}
+	var e error
+	if f.Since, e = parseTime(*since); e != nil {
+		return e
+	}
+	if f.Until, e = parseTime(*until); e != nil {
+		return e
+	}
+	ms, e := store.Mappings(db, f)
+	if e != nil {
+		return e
+	}
+	for _, m := range ms {
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\tv%d\n", m.Key(), m.TimelineTimeStamp, m.UserID, m.PostedAt.Format(time.RFC3339), m.Version)
+	}
+	return nil
+}
+
+func getMappings(db store.MappingReader, args []string, out io.Writer) error {
+	f, e := messageFilter(args)
+	if e != nil {
+		return e
+	}
+	ms, e := store.Mappings(db, f)
+	if e != nil {
+		return e
+	}
+	if len(ms) == 0 {
+		return fmt.Errorf("%s %s is not found", f.ChannelID, f.TimeStamp)
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	for _, m := range ms {
+		if e := encoder.Encode(m); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func removeMappings(db store.MappingStore, args []string, out io.Writer) error {
+	f, e := messageFilter(args)
+	if e != nil {
+		return e
+	}
+	n, e := store.Remove(db, f)
+	fmt.Fprintf(out, "removed %d\n", n)
+	return e
+}
+
+func printStats(db store.MappingReader, out io.Writer) error {
+	s, e := store.CollectStats(db)
+	if e != nil {
+		return e
+	}
+	fmt.Fprintf(out, "messages: %d\n", s.Mappings)
+	if !s.Oldest.IsZero() {
+		fmt.Fprintf(out, "oldest: %s\n", s.Oldest.Format(time.RFC3339))
+		fmt.Fprintf(out, "newest: %s\n", s.Newest.Format(time.RFC3339))
+	}
+	timelines := []string{}
+	for t := range s.Timelines {
+		timelines = append(timelines, t)
+	}
+	sort.Strings(timelines)
+	for _, t := range timelines {
+		fmt.Fprintf(out, "timeline %s: %d\n", t, s.Timelines[t])
+	}
+	for v := 0; v <= store.MappingVersion; v++ {
+		if n, found := s.Versions[v]; found {
+			fmt.Fprintf(out, "version %d: %d\n", v, n)
+		}
+	}
+	return nil
+}
+
+func messageFilter(args []string) (store.MappingFilter, error) {
+	if len(args) != 2 {
+		return store.MappingFilter{}, fmt.Errorf("channel and ts are required")
+	}
+	return store.MappingFilter{ChannelID: args[0], TimeStamp: args[1]}, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, e := time.Parse(time.RFC3339, s); e == nil {
+		return t, nil
+	}
+	t, e := time.ParseInLocation("2006-01-02", s, time.Local)
+	if e != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/store"
+)
+
+// newDBForTest returns the URL of a bolt db which has the mappings.
+func newDBForTest(t *testing.T, ms ...store.Mapping) string {
+	url := "bolt://" + filepath.Join(t.TempDir(), "timeline.bolt")
+	b, e := store.Open(url)
+	if !assert.NoError(t, e) {
+		t.FailNow()
+	}
+	defer b.Close()
+	s := store.NewMappingStore(b)
+	for _, m := range ms {
+		assert.NoError(t, s.Put(m))
+	}
+	return url
+}
+
+func runDBCommandForTest(url string, in string, args ...string) (int, string, string) {
+	out, errOut := bytes.Buffer{}, bytes.Buffer{}
+	code := runDBCommand(url, args, strings.NewReader(in), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+var mappingsForTest = []store.Mapping{
+	{Version: store.MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CT", TimelineTimeStamp: "9.1", UserID: "U1", PostedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{Version: store.MappingVersion, SourceChannelID: "C2", SourceTimeStamp: "2.0", TimelineChannelID: "CT", TimelineTimeStamp: "9.2", UserID: "U2", PostedAt: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},
+}
+
+func TestDBCommandPrintsUsage(t *testing.T) {
+	code, _, errOut := runDBCommandForTest("memory://", "")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, errOut, "usage:")
+
+	code, _, errOut = runDBCommandForTest("memory://", "", "unknown")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, errOut, "usage:")
+}
+
+func TestDBCommandDoesNotCreateDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.bolt")
+	code, _, errOut := runDBCommandForTest("bolt://"+path, "", "stats")
+	assert.Equal(t, 1, code)
+	assert.NotEmpty(t, errOut)
+	_, e := os.Stat(path)
+	assert.True(t, os.IsNotExist(e))
+}
+
+func TestDBCommandListsMappings(t *testing.T) {
+	url := newDBForTest(t, mappingsForTest...)
+
+	code, out, _ := runDBCommandForTest(url, "", "ls")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "C1-1.0-CT\t9.1\tU1\t2020-01-01T00:00:00Z\tv1\nC2-2.0-CT\t9.2\tU2\t2020-01-03T00:00:00Z\tv1\n", out)
+
+	code, out, _ = runDBCommandForTest(url, "", "ls", "-since", "2020-01-02T00:00:00Z")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "C2-2.0-CT\t9.2\tU2\t2020-01-03T00:00:00Z\tv1\n", out)
+
+	code, out, _ = runDBCommandForTest(url, "", "ls", "-channel", "C1")
+	assert.Equal(t, 0, code)
+	assert.True(t, strings.HasPrefix(out, "C1-1.0-CT\t"))
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+
+	code, _, errOut := runDBCommandForTest(url, "", "ls", "-since", "yesterday")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "invalid time: yesterday")
+}
+
+func TestDBCommandGetsAndRemovesMapping(t *testing.T) {
+	url := newDBForTest(t, mappingsForTest...)
+
+	code, out, _ := runDBCommandForTest(url, "", "get", "C1", "1.0")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, `"timelineTs": "9.1"`)
+
+	code, _, errOut := runDBCommandForTest(url, "", "get", "C1")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "channel and ts are required")
+
+	code, out, _ = runDBCommandForTest(url, "", "rm", "C1", "1.0")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "removed 1\n", out)
+
+	code, _, errOut = runDBCommandForTest(url, "", "get", "C1", "1.0")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "C1 1.0 is not found")
+}
+
+func TestDBCommandExportsAndImportsMappings(t *testing.T) {
+	code, exported, _ := runDBCommandForTest(newDBForTest(t, mappingsForTest...), "", "export")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, 2, strings.Count(exported, "\n"))
+
+	url := newDBForTest(t)
+	code, out, _ := runDBCommandForTest(url, exported, "import")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "imported 2\n", out)
+
+	code, out, _ = runDBCommandForTest(url, "", "stats")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "messages: 2\n")
+	assert.Contains(t, out, "timeline CT: 2\n")
+}
+
+func TestParseTime(t *testing.T) {
+	tm, e := parseTime("2020-01-02T03:04:05Z")
+	if assert.NoError(t, e) {
+		assert.Equal(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), tm)
+	}
+	tm, e = parseTime("2020-01-02")
+	if assert.NoError(t, e) {
+		assert.Equal(t, time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local), tm)
+	}
+	tm, e = parseTime("")
+	if assert.NoError(t, e) {
+		assert.True(t, tm.IsZero())
+	}
+	_, e = parseTime("01/02/2020")
+	assert.Error(t, e)
+}
+
+func TestDBCommandReadsLegacyMappingsWithoutMigrating(t *testing.T) {
+	url := newDBForTest(t, mappingsForTest...)
+	b, e := store.Open(url)
+	if !assert.NoError(t, e) {
+		return
+	}
+	b.Put("C3-3.0", []byte(`{"ok":true,"channel":"CT","ts":"9.3"}`))
+	b.Put("C4-4.0", []byte(`{"ok":false,"error":"channel_not_found"}`))
+	b.Close()
+
+	code, out, _ := runDBCommandForTest(url, "", "stats")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "messages: 3\n")
+	assert.Contains(t, out, "version 0: 1\n")
+	code, out, _ = runDBCommandForTest(url, "", "get", "C3", "3.0")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, `"version": 0`)
+
+	code, out, _ = runDBCommandForTest(url, "", "migrate")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "migrated 1\n", out)
+	code, out, _ = runDBCommandForTest(url, "", "stats")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "messages: 3\n")
+	assert.NotContains(t, out, "version 0")
+}
@@ -8,11 +8,14 @@ require (
 	github.com/codegangsta/cli v1.20.0 // indirect
 	github.com/getsentry/raven-go v0.0.0-20180517221441-ed7bcb39ff10
 	github.com/golang/snappy v0.0.0-20160529050041-d9eb7a3d35ec // indirect
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.6.0
 	github.com/syndtr/goleveldb v0.0.0-20161227110519-23851d93a229
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/net v0.0.0-20161229225711-8fd7f2595553
+	golang.org/x/sys v0.7.0
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
@@ -2,13 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
-
-	"github.com/syndtr/goleveldb/leveldb"
+	"time"
 
 	"github.com/ara-ta3/slack-timeline/logger"
 	"github.com/ara-ta3/slack-timeline/slack"
+	"github.com/ara-ta3/slack-timeline/store"
 	"github.com/ara-ta3/slack-timeline/timeline"
 )
 
@@ -16,45 +17,194 @@ var stdoutLogger = log.New(os.Stdout, "", log.Ldate+log.Ltime+log.Lshortfile)
 
 func main() {
 	filePath := flag.String("c", "config.json", "file path to config.json")
-	dbPath := flag.String("db", "db", "path of db for deleting message")
+	dbPath := flag.String("db", "", "path or URL of db for deleting message like bolt://timeline.db. Default is db in config or \"db\"")
 	flag.Parse()
+	if flag.Arg(0) == "db" {
+		config, e := ReadConfig(*filePath)
+		if e != nil && *dbPath == "" {
+			fmt.Fprintf(os.Stderr, "failed to read %s to find db. give -db instead: %+v\n", *filePath, e)
+			os.Exit(1)
+		}
+		if e != nil {
+			config = &Config{}
+		}
+		os.Exit(runDBCommand(config.DBURL(*dbPath), flag.Args()[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
 	stdoutLogger.Printf("filepath: %s\n", *filePath)
-	stdoutLogger.Printf("dbpath: %s\n", *dbPath)
 	config, e := ReadConfig(*filePath)
 	if e != nil {
 		stdoutLogger.Fatalf("%+v\n", e)
 	}
+	dbURL := config.DBURL(*dbPath)
+	stdoutLogger.Printf("dbpath: %s\n", dbURL)
 
 	reporter, e := logger.NewReporter(config.Sentry.DSN)
 	if e != nil {
 		stdoutLogger.Fatalf("%+v\n", e)
 	}
-	db, e := leveldb.OpenFile(*dbPath, nil)
+	backend, e := store.Open(dbURL)
 	if e != nil {
 		stdoutLogger.Fatalln(e)
 	}
-	defer db.Close()
+	defer backend.Close()
+	migrated, e := store.Migrate(backend)
+	if e != nil {
+		stdoutLogger.Fatalf("%+v\n", e)
+	}
+	if migrated > 0 {
+		stdoutLogger.Printf("migrated %d messages in db\n", migrated)
+	}
+	if config.Retention.Days > 0 {
+		interval := time.Duration(config.Retention.PruneIntervalHours) * time.Hour
+		if interval <= 0 {
+			interval = 24 * time.Hour
+		}
+		pruner := store.NewPruner(backend, time.Duration(config.Retention.Days)*24*time.Hour, interval, stdoutLogger)
+		go pruner.Run()
+	}
 	slackClient := slack.NewSlackClient(config.SlackAPIToken, stdoutLogger)
-	worker := slack.NewSlackTimelineWorker(slackClient)
+	slackClient.UsersPageSize = config.UsersPageSize
+	reconnectPolicy := slack.NewReconnectPolicy(
+		config.Reconnect.MaxRetries,
+		time.Duration(config.Reconnect.InitialIntervalSeconds)*time.Second,
+		time.Duration(config.Reconnect.MaxIntervalSeconds)*time.Second,
+	)
 	userRepository := slack.NewUserRepository(slackClient)
-	messageRepository := slack.NewMessageRepository(config.TimelineChannelID, slackClient, *db)
+	if config.UserCache.Persist {
+		ttl := time.Duration(config.UserCache.TTLHours) * time.Hour
+		if ttl <= 0 {
+			ttl = 24 * time.Hour
+		}
+		userRepository = slack.NewPersistentUserRepository(slackClient, backend, ttl, stdoutLogger)
+	}
+	channelRepository := slack.NewChannelRepository(slackClient)
+	router := timeline.NewRouter(config.TimelineRoutes(), channelRepository)
+	if e := config.CheckTemplates(); e != nil {
+		stdoutLogger.Fatalf("%+v\n", e)
+	}
+	var renderer slack.Renderer
+	switch config.Renderer {
+	case "", "plain":
+		renderer = slack.PlainTextRenderer{}
+	case "blocks":
+		renderer = slack.NewBlockKitRenderer(slackClient, stdoutLogger)
+	case "template":
+		renderer, e = slack.NewTemplateRenderer(
+			config.Template,
+			config.RouteTemplates(),
+			router,
+			slackClient,
+			channelRepository,
+			stdoutLogger,
+		)
+		if e != nil {
+			stdoutLogger.Fatalf("%+v\n", e)
+		}
+	default:
+		stdoutLogger.Fatalf("unknown renderer: %s\n", config.Renderer)
+	}
+	messageRepository := slack.NewMessageRepository(config.TimelineChannelID, slackClient, store.NewMappingStore(backend), renderer)
+	backfiller := slack.NewHistoryBackfiller(slackClient, messageRepository, stdoutLogger)
+	var worker timeline.TimelineWorker
+	switch config.Mode {
+	case "", "rtm":
+		worker = slack.NewSlackTimelineWorker(slackClient, backfiller, reconnectPolicy, stdoutLogger)
+	case "events":
+		if config.Events.SigningSecret == "" {
+			stdoutLogger.Fatalln("events.signingSecret is required in events mode")
+		}
+		worker = slack.NewEventsAPIWorker(
+			config.Events.ListenAddress,
+			config.Events.Path,
+			config.Events.SigningSecret,
+			backfiller,
+			stdoutLogger,
+		)
+	case "socket":
+		worker = slack.NewSocketModeWorker(
+			slackClient,
+			config.SocketMode.AppToken,
+			backfiller,
+			reconnectPolicy,
+			stdoutLogger,
+		)
+	default:
+		stdoutLogger.Fatalf("unknown mode: %s\n", config.Mode)
+	}
+	filters, e := config.MessageFilters()
+	if e != nil {
+		stdoutLogger.Fatalf("%+v\n", e)
+	}
+	var debugLogger *log.Logger
+	if config.Debug {
+		debugLogger = stdoutLogger
+	}
+	identity, e := slackClient.Identify()
+	if e != nil {
+		reporter.Report(e)
+		log.Fatalf("%+v\n", e)
+	}
 	messageValidator := timeline.MessageValidator{
-		TimelineChannelID:   config.TimelineChannelID,
-		BlackListChannelIDs: config.BlackListChannelIDs,
+		TimelineChannelIDs:    router.TimelineChannelIDs(),
+		BlackListChannelIDs:   config.BlackListChannelIDs,
+		WhiteListChannelIDs:   config.WhiteListChannelIDs,
+		WhiteListChannelNames: config.WhiteListChannelNames,
+		ChannelRepository:     channelRepository,
+		OwnUserID:             identity.UserID,
+		OwnBotID:              identity.BotID,
+		Filters:               filters,
+		DebugLogger:           debugLogger,
+		Logger:                stdoutLogger,
 	}
 
+	nameField := timeline.NameField(config.UserNameField)
+	switch nameField {
+	case "":
+		nameField = timeline.NameFieldName
+	case timeline.NameFieldName, timeline.NameFieldDisplayName, timeline.NameFieldRealName:
+	default:
+		stdoutLogger.Fatalf("unknown userNameField: %s\n", config.UserNameField)
+	}
+
+	namedUserRepository := timeline.WithNameField(userRepository, nameField)
+	// users are loaded at once on start so that mentions do not call users.info one by one
+	if us, e := namedUserRepository.GetAll(); e != nil {
+		stdoutLogger.Printf("failed to load users: %+v\n", e)
+	} else {
+		stdoutLogger.Printf("loaded %d users\n", len(us))
+	}
+	idReplacerFactory := timeline.NewIDReplacerFactory(
+		namedUserRepository,
+		slack.NewUserGroupRepository(slackClient),
+		channelRepository,
+	)
+	idReplacerFactory.Logger = stdoutLogger
 	service, e := timeline.NewTimelineService(
 		worker,
-		userRepository,
+		namedUserRepository,
 		messageRepository,
 		messageValidator,
-		*stdoutLogger,
+		router,
+		idReplacerFactory,
+		stdoutLogger,
 	)
 
 	if e != nil {
 		reporter.Report(e)
 		log.Fatalf("%+v\n", e)
 	}
+	switch p := timeline.ThreadReplyPolicy(config.ThreadReplies); p {
+	case "":
+		service.ThreadReplyPolicy = timeline.ThreadReplyInThread
+	case timeline.ThreadReplyInThread, timeline.ThreadReplyFlatten:
+		service.ThreadReplyPolicy = p
+	default:
+		stdoutLogger.Fatalf("unknown threadReplies: %s\n", config.ThreadReplies)
+	}
+	if config.Reactions.Mirror {
+		service.ReactionRepository = slack.NewReactionRepository(slackClient)
+		service.AggregateReactions = config.Reactions.Aggregate
+	}
 
 	err := service.Run()
 	if err != nil {
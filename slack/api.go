@@ -0,0 +1,127 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+// apiResponse is the envelope of every response of the Web API.
+// Responses of each method embed it.
+type apiResponse struct {
+	OK               bool             `json:"ok"`
+	Error            string           `json:"error"`
+	Warning          string           `json:"warning"`
+	ResponseMetadata responseMetadata `json:"response_metadata"`
+}
+
+func (r apiResponse) envelope() apiResponse {
+	return r
+}
+
+// warnings returns the warnings in both of the old and the new fields.
+func (r apiResponse) warnings() []string {
+	ws := []string{}
+	if r.Warning != "" {
+		ws = append(ws, strings.Split(r.Warning, ",")...)
+	}
+	return append(ws, r.ResponseMetadata.Warnings...)
+}
+
+type responseMetadata struct {
+	NextCursor string   `json:"next_cursor"`
+	Warnings   []string `json:"warnings"`
+}
+
+type webAPIResponse interface {
+	envelope() apiResponse
+}
+
+// APIError is returned when the Web API responded with ok:false.
+type APIError struct {
+	Method   string
+	Code     string
+	Warnings []string
+}
+
+func (e APIError) Error() string {
+	if len(e.Warnings) == 0 {
+		return fmt.Sprintf("%s failed: %s", e.Method, e.Code)
+	}
+	return fmt.Sprintf("%s failed: %s (warnings: %s)", e.Method, e.Code, strings.Join(e.Warnings, ", "))
+}
+
+// Kind tells whether the call can be tried again, or fails forever for the token or only for the event.
+func (e APIError) Kind() timeline.ErrorKind {
+	switch e.Code {
+	case "ratelimited", "internal_error", "fatal_error", "service_unavailable", "request_timeout":
+		return timeline.ErrorRetryable
+	case "invalid_auth", "not_authed", "account_inactive", "token_revoked", "token_expired",
+		"no_permission", "missing_scope", "not_allowed_token_type", "org_login_required", "ekm_access_denied":
+		return timeline.ErrorFatal
+	default:
+		return timeline.ErrorSkippable
+	}
+}
+
+// RequestError is returned when the Web API could not be called or its response could not be read.
+// It is retryable since it is usually a problem of the network.
+type RequestError struct {
+	Method string
+	Err    error
+}
+
+func (e RequestError) Error() string {
+	return fmt.Sprintf("failed to call %s: %s", e.Method, e.Err.Error())
+}
+
+func (e RequestError) Cause() error {
+	return e.Err
+}
+
+func (e RequestError) Kind() timeline.ErrorKind {
+	return timeline.ErrorRetryable
+}
+
+// call posts params with the token to the method of the Web API and decodes the response into res.
+// It calls the method only once. Network errors and ratelimited are retryable, and the service
+// retries them without blocking other events.
+func (cli *SlackClient) call(method string, params url.Values, res webAPIResponse) error {
+	params.Set("token", cli.Token)
+	r, e := http.PostForm(slackAPIEndpoint+method, params)
+	if e != nil {
+		return RequestError{Method: method, Err: e}
+	}
+	return decodeResponse(method, r, res)
+}
+
+// decodeResponse decodes the response of the method into res.
+// It returns APIError when the response is ok:false.
+func decodeResponse(method string, r *http.Response, res webAPIResponse) error {
+	defer r.Body.Close()
+	b, e := ioutil.ReadAll(r.Body)
+	if e != nil {
+		return RequestError{Method: method, Err: errors.Wrap(e, fmt.Sprintf("failed read all. response: %+v", r))}
+	}
+	e = json.Unmarshal(b, res)
+	if e != nil {
+		return RequestError{Method: method, Err: errors.Wrap(e, fmt.Sprintf("failed to Unmarshal response body. body: %s", string(b)))}
+	}
+	if env := res.envelope(); !env.OK {
+		return APIError{Method: method, Code: env.Error, Warnings: env.warnings()}
+	}
+	return nil
+}
+
+// isAPIError returns whether the Web API responded with the error code.
+func isAPIError(e error, code string) bool {
+	a, ok := errors.Cause(e).(APIError)
+	return ok && a.Code == code
+}
@@ -0,0 +1,77 @@
+package slack
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+type Backfiller interface {
+	Backfill(messageChan chan *timeline.Message) error
+}
+
+type latestTimeStampFinder interface {
+	LatestTimeStamp(channelID string) (string, error)
+}
+
+// HistoryBackfiller sends the messages which were posted while the worker was
+// not connected, reading conversations.history from the newest stored message.
+type HistoryBackfiller struct {
+	SlackClient *SlackClient
+	store       latestTimeStampFinder
+	logger      *log.Logger
+}
+
+func NewHistoryBackfiller(s SlackClient, store latestTimeStampFinder, logger *log.Logger) HistoryBackfiller {
+	return HistoryBackfiller{
+		SlackClient: &s,
+		store:       store,
+		logger:      logger,
+	}
+}
+
+func (b HistoryBackfiller) Backfill(messageChan chan *timeline.Message) error {
+	channels, e := b.SlackClient.getPublicChannels()
+	if e != nil {
+		return errors.Wrap(e, "failed to get channels to backfill")
+	}
+	missed := []SlackMessage{}
+	for _, c := range channels {
+		latest, e := b.store.LatestTimeStamp(c.ID)
+		if e != nil {
+			return errors.Wrap(e, fmt.Sprintf("failed to get latest timestamp. channel: %s", c.ID))
+		}
+		if latest == "" {
+			// never posted from this channel, so there is nothing to catch up
+			continue
+		}
+		ms, e := b.SlackClient.getHistory(c.ID, latest)
+		if e != nil {
+			b.logger.Printf("skipped backfilling %s: %+v\n", c.ID, e)
+			continue
+		}
+		for _, m := range ms {
+			if !m.IsMessageToPost() {
+				continue
+			}
+			m.ChannelID = c.ID
+			missed = append(missed, m)
+		}
+	}
+	// timestamps have fixed number of digits so that they can be compared as strings
+	sort.SliceStable(missed, func(i, j int) bool {
+		return missed[i].TimeStamp < missed[j].TimeStamp
+	})
+	if len(missed) > 0 {
+		b.logger.Printf("backfilling %d messages\n", len(missed))
+	}
+	for _, m := range missed {
+		msg := m.ToInternal()
+		messageChan <- &msg
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package slack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/store"
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+type latestTimeStampsOnMemory map[string]string
+
+func (l latestTimeStampsOnMemory) LatestTimeStamp(channelID string) (string, error) {
+	return l[channelID], nil
+}
+
+func newSlackAPIStandIn(handler http.HandlerFunc) func() {
+	server := httptest.NewServer(handler)
+	orig := slackAPIEndpoint
+	slackAPIEndpoint = server.URL + "/"
+	return func() {
+		slackAPIEndpoint = orig
+		server.Close()
+	}
+}
+
+func newSlackClientForTest() SlackClient {
+	return NewSlackClient("token", log.New(ioutil.Discard, "", 0))
+}
+
+func TestBackfillSendsMissedMessagesInOrder(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/conversations.list":
+			fmt.Fprint(w, `{"ok":true,"channels":[{"id":"C1"},{"id":"C2"},{"id":"C3"}]}`)
+		case "/conversations.history":
+			assert.Equal(t, "1000000000.000000", r.Form.Get("oldest"))
+			switch r.Form.Get("channel") + r.Form.Get("cursor") {
+			case "C1":
+				fmt.Fprint(w, `{"ok":true,"messages":[{"type":"message","user":"U1","text":"c1 third","ts":"1000000003.000000"}],"has_more":true,"response_metadata":{"next_cursor":"next"}}`)
+			case "C1next":
+				fmt.Fprint(w, `{"ok":true,"messages":[{"type":"message","subtype":"channel_join","user":"U1","text":"joined","ts":"1000000002.000000"},{"type":"message","user":"U1","text":"c1 first","ts":"1000000001.000000"}],"has_more":false}`)
+			case "C2":
+				fmt.Fprint(w, `{"ok":true,"messages":[{"type":"message","user":"U2","text":"c2 second","ts":"1000000002.500000"}],"has_more":false}`)
+			default:
+				t.Errorf("unexpected history request %+v", r.Form)
+			}
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	})
+	defer closeServer()
+
+	store := latestTimeStampsOnMemory{
+		"C1": "1000000000.000000",
+		"C2": "1000000000.000000",
+	}
+	b := NewHistoryBackfiller(newSlackClientForTest(), store, log.New(ioutil.Discard, "", 0))
+	messageChan := make(chan *timeline.Message, 10)
+	e := b.Backfill(messageChan)
+	close(messageChan)
+
+	if assert.NoError(t, e) {
+		texts := []string{}
+		for m := range messageChan {
+			texts = append(texts, m.ChannelID+" "+m.Text)
+		}
+		assert.Equal(t, []string{"C1 c1 first", "C2 c2 second", "C1 c1 third"}, texts)
+	}
+}
+
+func TestLatestTimeStamp(t *testing.T) {
+	db := newMappingStoreForTest()
+	for _, posted := range []struct{ channelID, ts string }{
+		{"C1", "1000000001.000000"},
+		{"C1", "1000000003.000000"},
+		{"C1", "1000000004.000000"},
+		{"C10", "1000000009.000000"},
+		{"C2", "1000000002.000000"},
+	} {
+		db.Put(store.Mapping{Version: store.MappingVersion, SourceChannelID: posted.channelID, SourceTimeStamp: posted.ts, TimelineChannelID: "CT", TimelineTimeStamp: "9.0"})
+	}
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+
+	latest, e := r.LatestTimeStamp("C1")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "1000000004.000000", latest)
+	}
+	latest, e = r.LatestTimeStamp("C3")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "", latest)
+	}
+}
@@ -0,0 +1,53 @@
+package slack
+
+import (
+	"time"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+	cache "github.com/patrickmn/go-cache"
+)
+
+// channelCacheExpiration is how long a channel is cached so that renamed channels are picked up.
+var channelCacheExpiration = 1 * time.Hour
+
+func NewChannelRepository(s SlackClient) ChannelRepositoryOnSlack {
+	c := cache.New(channelCacheExpiration, 24*time.Hour)
+	return ChannelRepositoryOnSlack{
+		SlackClient: s,
+		cache:       c,
+	}
+}
+
+type ChannelRepositoryOnSlack struct {
+	SlackClient SlackClient
+	cache       *cache.Cache
+}
+
+func (r ChannelRepositoryOnSlack) Get(channelID string) (*timeline.Channel, error) {
+	c, found := r.cache.Get(channelID)
+	if ret, ok := c.(timeline.Channel); found && ok {
+		return &ret, nil
+	}
+	cc, err := r.SlackClient.getChannel(channelID)
+	if err != nil {
+		return nil, err
+	}
+	ch := cc.ToInternal()
+	r.cache.Set(channelID, ch, cache.DefaultExpiration)
+	return &ch, nil
+}
+
+// GetAll returns all public channels and caches them.
+func (r ChannelRepositoryOnSlack) GetAll() ([]timeline.Channel, error) {
+	cs, err := r.SlackClient.getPublicChannels()
+	if err != nil {
+		return nil, err
+	}
+	channels := []timeline.Channel{}
+	for _, c := range cs {
+		ch := c.ToInternal()
+		r.cache.Set(ch.ID, ch, cache.DefaultExpiration)
+		channels = append(channels, ch)
+	}
+	return channels, nil
+}
@@ -3,9 +3,9 @@ package slack
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/url"
+	"strconv"
 
 	"github.com/ara-ta3/slack-timeline/timeline"
 	"github.com/pkg/errors"
@@ -20,9 +20,18 @@ var slackAPIEndpoint = "https://slack.com/api/"
 var origin = "http://localhost"
 
 type rtmStartResponse struct {
-	OK    bool   `json:"ok"`
-	URL   string `json:"url"`
-	Error string `json:"error"`
+	apiResponse
+	URL string `json:"url"`
+}
+
+type connectionsOpenResponse struct {
+	apiResponse
+	URL string `json:"url"`
+}
+
+type userEvent struct {
+	Type string `json:"type"`
+	User User   `json:"user"`
 }
 
 type deletedEvent struct {
@@ -30,61 +39,200 @@ type deletedEvent struct {
 	Message   SlackMessage `json:"previous_message"`
 }
 
+type reactionEvent struct {
+	Type     string `json:"type"`
+	UserID   string `json:"user"`
+	Reaction string `json:"reaction"`
+	Item     struct {
+		Type      string `json:"type"`
+		ChannelID string `json:"channel"`
+		TimeStamp string `json:"ts"`
+	} `json:"item"`
+}
+
+func (r reactionEvent) ToInternal() timeline.Reaction {
+	return timeline.NewReaction(r.Reaction, r.UserID, r.Item.ChannelID, r.Item.TimeStamp)
+}
+
+type changedEvent struct {
+	ChannelID       string       `json:"channel"`
+	Message         SlackMessage `json:"message"`
+	PreviousMessage SlackMessage `json:"previous_message"`
+}
+
 type SlackMessage struct {
-	Raw       string `json:"-"`
-	Type      string `json:"type"`
-	UserID    string `json:"user"`
+	Raw             string       `json:"-"`
+	Type            string       `json:"type"`
+	UserID          string       `json:"user"`
+	Text            string       `json:"text"`
+	ChannelID       string       `json:"channel"`
+	TimeStamp       string       `json:"ts"`
+	SubType         string       `json:"subtype"`
+	ThreadTimeStamp string       `json:"thread_ts"`
+	ParentUserID    string       `json:"parent_user_id"`
+	BotID           string       `json:"bot_id"`
+	Username        string       `json:"username"`
+	Icons           botIcons     `json:"icons"`
+	Attachments     []attachment `json:"attachments"`
+	Files           []file       `json:"files"`
+}
+
+// botIcons are the icons bot messages are posted with.
+type botIcons struct {
+	Image48 string `json:"image_48"`
+	Image72 string `json:"image_72"`
+}
+
+type attachment struct {
+	Pretext   string `json:"pretext"`
+	Title     string `json:"title"`
+	TitleLink string `json:"title_link"`
 	Text      string `json:"text"`
-	ChannelID string `json:"channel"`
-	TimeStamp string `json:"ts"`
-	SubType   string `json:"subtype"`
+	Fallback  string `json:"fallback"`
+}
+
+func (a attachment) ToInternal() timeline.Attachment {
+	return timeline.Attachment{
+		Pretext:   ReplaceIdFormatToName(a.Pretext),
+		Title:     a.Title,
+		TitleLink: a.TitleLink,
+		Text:      ReplaceIdFormatToName(a.Text),
+		Fallback:  a.Fallback,
+	}
+}
+
+type file struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Title     string `json:"title"`
+	MimeType  string `json:"mimetype"`
+	Permalink string `json:"permalink"`
+	Thumb360  string `json:"thumb_360"`
+	Thumb480  string `json:"thumb_480"`
+}
+
+func (f file) ToInternal() timeline.File {
+	thumb := f.Thumb480
+	if thumb == "" {
+		thumb = f.Thumb360
+	}
+	return timeline.File{
+		ID:        f.ID,
+		Name:      f.Name,
+		Title:     f.Title,
+		MimeType:  f.MimeType,
+		Permalink: f.Permalink,
+		Thumbnail: thumb,
+	}
 }
 
 func (m *SlackMessage) IsMessageToPost() bool {
-	return m.SubType == "" || m.isFileShare()
+	return m.SubType == "" || m.isFileShare() || m.isBotMessage()
 }
 
 func (m *SlackMessage) IsDeletedMessage() bool {
 	return m.SubType == "message_deleted"
 }
 
+func (m *SlackMessage) IsChangedMessage() bool {
+	return m.SubType == "message_changed"
+}
+
 func (m *SlackMessage) isFileShare() bool {
 	return m.SubType == "file_share"
 }
 
+func (m *SlackMessage) isBotMessage() bool {
+	return m.SubType == "bot_message"
+}
+
 func (m *SlackMessage) ToInternal() timeline.Message {
-	return timeline.NewMessage(
+	msg := timeline.NewMessage(
 		ReplaceIdFormatToName(m.Text),
 		m.UserID,
 		m.ChannelID,
 		m.TimeStamp,
 	)
+	msg.ThreadTimeStamp = m.ThreadTimeStamp
+	msg.ParentUserID = m.ParentUserID
+	msg.BotID = m.BotID
+	if m.BotID != "" {
+		msg.BotName = m.Username
+		msg.BotIconURL = m.Icons.Image72
+		if msg.BotIconURL == "" {
+			msg.BotIconURL = m.Icons.Image48
+		}
+	}
+	for _, a := range m.Attachments {
+		msg.Attachments = append(msg.Attachments, a.ToInternal())
+	}
+	for _, f := range m.Files {
+		msg.Files = append(msg.Files, f.ToInternal())
+	}
+	return msg
+}
+
+type postMessageResponse struct {
+	apiResponse
+	ChannelID string `json:"channel"`
+	TimeStamp string `json:"ts"`
+}
+
+type authTestResponse struct {
+	apiResponse
+	UserID string `json:"user_id"`
+	BotID  string `json:"bot_id"`
+}
+
+// Identity is who the token posts to the timeline as.
+type Identity struct {
+	UserID string
+	BotID  string
 }
 
 type userListResponse struct {
-	OK    bool   `json:"ok"`
-	User  User   `json:"user"`
-	Error string `json:"error"`
+	apiResponse
+	User User `json:"user"`
 }
 
 type allUserResponse struct {
-	OK      bool   `json:"ok"`
+	apiResponse
 	Members []User `json:"members"`
-	Error   string `json:"error"`
 }
 
 type User struct {
-	ID      string  `json:"id"`
-	Name    string  `json:"name"`
-	Profile profile `json:"profile"`
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	RealName string  `json:"real_name"`
+	Profile  profile `json:"profile"`
 }
 
 func (u User) ToInternal() timeline.User {
-	return timeline.NewUser(u.ID, u.Name, u.Profile.ImageURL)
+	user := timeline.NewUser(u.ID, u.Name, u.Profile.ImageURL)
+	user.DisplayName = u.Profile.DisplayName
+	user.RealName = u.Profile.RealName
+	if user.RealName == "" {
+		user.RealName = u.RealName
+	}
+	user.ProfileImageURLs = timeline.ProfileImageURLs{
+		Size24:  u.Profile.ImageURL24,
+		Size32:  u.Profile.ImageURL32,
+		Size72:  u.Profile.ImageURL72,
+		Size192: u.Profile.ImageURL192,
+		Size512: u.Profile.ImageURL512,
+	}
+	return user
 }
 
 type profile struct {
-	ImageURL string `json:"image_48"`
+	DisplayName string `json:"display_name"`
+	RealName    string `json:"real_name"`
+	ImageURL    string `json:"image_48"`
+	ImageURL24  string `json:"image_24"`
+	ImageURL32  string `json:"image_32"`
+	ImageURL72  string `json:"image_72"`
+	ImageURL192 string `json:"image_192"`
+	ImageURL512 string `json:"image_512"`
 }
 
 type channelCreated struct {
@@ -98,8 +246,63 @@ type channel struct {
 	Creator string `json:"creator"`
 }
 
+func (c channel) ToInternal() timeline.Channel {
+	return timeline.NewChannel(c.ID, c.Name)
+}
+
+type conversationInfoResponse struct {
+	apiResponse
+	Channel channel `json:"channel"`
+}
+
+type permalinkResponse struct {
+	apiResponse
+	Permalink string `json:"permalink"`
+}
+
+type conversationListResponse struct {
+	apiResponse
+	Channels []channel `json:"channels"`
+}
+
+type userGroup struct {
+	ID     string `json:"id"`
+	Handle string `json:"handle"`
+	Name   string `json:"name"`
+}
+
+func (g userGroup) ToInternal() timeline.UserGroup {
+	return timeline.NewUserGroup(g.ID, g.Handle, g.Name)
+}
+
+type userGroupListResponse struct {
+	apiResponse
+	UserGroups []userGroup `json:"usergroups"`
+}
+
+type reactionsResponse struct {
+	apiResponse
+	Message struct {
+		Reactions []struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		} `json:"reactions"`
+	} `json:"message"`
+}
+
+type historyResponse struct {
+	apiResponse
+	Messages []SlackMessage `json:"messages"`
+	HasMore  bool           `json:"has_more"`
+}
+
+// defaultUsersPageSize is the number of users fetched by one users.list request.
+var defaultUsersPageSize = 200
+
 type SlackClient struct {
-	Token            string
+	Token string
+	// UsersPageSize is the number of users fetched by one users.list request.
+	UsersPageSize    int
 	requestWithRetry SlackRetryAble
 }
 
@@ -123,131 +326,297 @@ func (cli SlackClient) ConnectToRTM() (RTMConnection, error) {
 		"token": {cli.Token},
 	}
 	r, e := cli.requestWithRetry.GetRequest(rtmStartURL + "?" + v.Encode())
-
 	if e != nil {
-		e := errors.Wrap(e, "failed to start rtm connection")
+		e := errors.Wrap(RequestError{Method: "rtm.start", Err: e}, "failed to start rtm connection")
 		return SlackRTMConnection{}, e
 	}
-	defer r.Body.Close()
-	byteArray, e := ioutil.ReadAll(r.Body)
+	res := rtmStartResponse{}
+	e = decodeResponse("rtm.start", r, &res)
 	if e != nil {
-		e := errors.Wrap(e, fmt.Sprintf("failed read body on starting rtm connection. response: %+v", r))
+		e := errors.Wrap(e, "failed to start rtm connection")
 		return SlackRTMConnection{}, e
 	}
-	res := rtmStartResponse{}
-	e = json.Unmarshal(byteArray, &res)
+	ws, e := websocket.Dial(res.URL, "", origin)
 	if e != nil {
-		e := errors.Wrap(e, fmt.Sprintf("failed unmarshal body on starting rtm connection. response: %+v", r))
+		e := errors.Wrap(e, fmt.Sprintf("failed dialing to websocket. response: %+v", res))
 		return SlackRTMConnection{}, e
 	}
-	if !res.OK {
-		return SlackRTMConnection{}, fmt.Errorf(res.Error)
+	return SlackRTMConnection{
+		ws: ws,
+	}, nil
+}
+
+// OpenSocketMode connects to Socket Mode with an app-level token, which starts with "xapp-".
+func (cli SlackClient) OpenSocketMode(appToken string) (SocketModeConnection, error) {
+	r, e := cli.requestWithRetry.PostReqestWithToken(slackAPIEndpoint+"apps.connections.open", url.Values{}, appToken)
+	if e != nil {
+		e := errors.Wrap(RequestError{Method: "apps.connections.open", Err: e}, "failed to open socket mode connection")
+		return SlackSocketModeConnection{}, e
+	}
+	res := connectionsOpenResponse{}
+	e = decodeResponse("apps.connections.open", r, &res)
+	if e != nil {
+		e := errors.Wrap(e, "failed to open socket mode connection")
+		return SlackSocketModeConnection{}, e
 	}
 	ws, e := websocket.Dial(res.URL, "", origin)
 	if e != nil {
 		e := errors.Wrap(e, fmt.Sprintf("failed dialing to websocket. response: %+v", res))
-		return SlackRTMConnection{}, e
+		return SlackSocketModeConnection{}, e
 	}
-	return SlackRTMConnection{
+	return SlackSocketModeConnection{
 		ws: ws,
 	}, nil
 }
 
-func (cli *SlackClient) postMessage(channelID, text, userName, iconURL string) ([]byte, error) {
-	res, e := cli.requestWithRetry.PostReqest(slackAPIEndpoint+"chat.postMessage", url.Values{
-		"token":      {cli.Token},
+// Identify returns the user and the bot of the token by auth.test.
+func (cli *SlackClient) Identify() (Identity, error) {
+	r := authTestResponse{}
+	e := cli.call("auth.test", url.Values{}, &r)
+	if e != nil {
+		e = errors.Wrap(e, "failed to test auth")
+		return Identity{}, e
+	}
+	return Identity{UserID: r.UserID, BotID: r.BotID}, nil
+}
+
+// postMessage posts the message and returns where it was posted, which fails on ok:false.
+// It is posted again with the fallback blocks if Slack rejects the blocks.
+func (cli *SlackClient) postMessage(channelID, threadTS string, r RenderedMessage, userName, iconURL string) (*postMessageResponse, error) {
+	text := r.Text
+	params := url.Values{
 		"channel":    {channelID},
 		"text":       {text},
 		"username":   {userName},
 		"as_user":    {"false"},
 		"icon_url":   {iconURL},
 		"link_names": {"0"},
-	})
-	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed to post message. user: %s, channel: %s. text: %s", userName, channelID, text))
-		return nil, e
 	}
-	defer res.Body.Close()
-	byteArray, e := ioutil.ReadAll(res.Body)
+	if threadTS != "" {
+		params.Set("thread_ts", threadTS)
+	}
+	if r.Blocks != "" {
+		params.Set("blocks", r.Blocks)
+	}
+	posted := postMessageResponse{}
+	e := cli.call("chat.postMessage", params, &posted)
+	if isAPIError(e, "invalid_blocks") && r.FallbackBlocks != "" {
+		params.Set("blocks", r.FallbackBlocks)
+		e = cli.call("chat.postMessage", params, &posted)
+	}
 	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed read all. response: %+v", res))
+		e = errors.Wrap(e, fmt.Sprintf("failed to post message. user: %s, channel: %s. text: %s", userName, channelID, text))
 		return nil, e
 	}
-	return byteArray, nil
+	return &posted, nil
 }
 
-func (cli *SlackClient) getUser(userID string) (*User, error) {
-	res, e := cli.requestWithRetry.PostReqest(slackAPIEndpoint+"users.info", url.Values{
-		"token": {cli.Token},
-		"user":  {userID},
-	})
+func (cli *SlackClient) updateMessage(channelID, ts string, r RenderedMessage) error {
+	text := r.Text
+	params := url.Values{
+		"channel":    {channelID},
+		"ts":         {ts},
+		"text":       {text},
+		"as_user":    {"false"},
+		"link_names": {"0"},
+	}
+	if r.Blocks != "" {
+		params.Set("blocks", r.Blocks)
+	}
+	e := cli.call("chat.update", params, &apiResponse{})
+	if isAPIError(e, "invalid_blocks") && r.FallbackBlocks != "" {
+		params.Set("blocks", r.FallbackBlocks)
+		e = cli.call("chat.update", params, &apiResponse{})
+	}
 	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed to get user info. user: %s", userID))
-		return nil, e
+		e = errors.Wrap(e, fmt.Sprintf("failed to update message. ts: %s, channel: %s. text: %s", ts, channelID, text))
+		return e
 	}
-	defer res.Body.Close()
-	b, e := ioutil.ReadAll(res.Body)
+	return nil
+}
+
+func (cli *SlackClient) getPermalink(channelID, ts string) (string, error) {
+	r := permalinkResponse{}
+	e := cli.call("chat.getPermalink", url.Values{
+		"channel":    {channelID},
+		"message_ts": {ts},
+	}, &r)
 	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed read all. response: %+v", res))
-		return nil, e
+		e = errors.Wrap(e, fmt.Sprintf("failed to get permalink. ts: %s, channel: %s", ts, channelID))
+		return "", e
 	}
+	return r.Permalink, nil
+}
+
+func (cli *SlackClient) getUser(userID string) (*User, error) {
 	r := userListResponse{}
-	e = json.Unmarshal(b, &r)
+	e := cli.call("users.info", url.Values{
+		"user": {userID},
+	}, &r)
 	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed to Unmarshal response body on get user info. body: %+v", b))
+		e = errors.Wrap(e, fmt.Sprintf("failed to get user info. user: %s", userID))
 		return nil, e
 	}
-	if !r.OK {
-		return nil, fmt.Errorf(r.Error)
-	}
 	u := r.User
 	return &u, nil
 }
 
 func (cli *SlackClient) getAllUsers() ([]User, error) {
-	res, e := cli.requestWithRetry.PostReqest(slackAPIEndpoint+"users.list", url.Values{
-		"token": {cli.Token},
-	})
-	if e != nil {
-		e = errors.Wrap(e, "failed to get user lists.")
-		return nil, e
+	limit := cli.UsersPageSize
+	if limit <= 0 {
+		limit = defaultUsersPageSize
+	}
+	users := []User{}
+	cursor := ""
+	for {
+		r := allUserResponse{}
+		e := cli.call("users.list", url.Values{
+			"limit":  {strconv.Itoa(limit)},
+			"cursor": {cursor},
+		}, &r)
+		if e != nil {
+			e = errors.Wrap(e, "failed to get user lists.")
+			return nil, e
+		}
+		users = append(users, r.Members...)
+		cursor = r.ResponseMetadata.NextCursor
+		if cursor == "" {
+			return users, nil
+		}
 	}
-	defer res.Body.Close()
-	b, e := ioutil.ReadAll(res.Body)
+}
+
+func (cli *SlackClient) deleteMessage(ts, channel string) error {
+	e := cli.call("chat.delete", url.Values{
+		"ts":      {ts},
+		"channel": {channel},
+	}, &apiResponse{})
 	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed read all. response: %+v", res))
-		return nil, e
+		e = errors.Wrap(e, fmt.Sprintf("failed to delete message. ts: %s, channel: %s", ts, channel))
+		return e
 	}
-	r := allUserResponse{}
-	e = json.Unmarshal(b, &r)
+	return nil
+}
+
+// addReaction adds the reaction of the bot to the message.
+// It succeeds if the bot has already reacted with it.
+func (cli *SlackClient) addReaction(channelID, ts, name string) error {
+	return cli.postReaction("reactions.add", channelID, ts, name, "already_reacted")
+}
+
+// removeReaction removes the reaction of the bot from the message.
+// It succeeds if the bot has not reacted with it.
+func (cli *SlackClient) removeReaction(channelID, ts, name string) error {
+	return cli.postReaction("reactions.remove", channelID, ts, name, "no_reaction")
+}
+
+// countReaction returns the number of users who reacted to the message with the reaction.
+func (cli *SlackClient) countReaction(channelID, ts, name string) (int, error) {
+	r := reactionsResponse{}
+	e := cli.call("reactions.get", url.Values{
+		"channel":   {channelID},
+		"timestamp": {ts},
+	}, &r)
 	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed to Unmarshal response body on get users lists. body: %+v, response: %+v", string(b), res))
-		return nil, e
+		e = errors.Wrap(e, fmt.Sprintf("failed to get reactions. channel: %s, ts: %s", channelID, ts))
+		return 0, e
 	}
-	if !r.OK {
-		return nil, fmt.Errorf(r.Error)
+	for _, reaction := range r.Message.Reactions {
+		if reaction.Name == name {
+			return reaction.Count, nil
+		}
 	}
-	return r.Members, nil
+	return 0, nil
 }
 
-func (cli *SlackClient) deleteMessage(ts, channel string) ([]byte, error) {
-	res, e := cli.requestWithRetry.PostReqest(
-		slackAPIEndpoint+"chat.delete",
-		url.Values{
-			"token":   {cli.Token},
-			"ts":      {ts},
-			"channel": {channel},
-		},
-	)
+func (cli *SlackClient) postReaction(method, channelID, ts, name, ignoredError string) error {
+	e := cli.call(method, url.Values{
+		"channel":   {channelID},
+		"timestamp": {ts},
+		"name":      {name},
+	}, &apiResponse{})
+	if isAPIError(e, ignoredError) {
+		return nil
+	}
 	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed to delete message. ts: %s, channel: %s", ts, channel))
+		e = errors.Wrap(e, fmt.Sprintf("failed to %s. channel: %s, ts: %s, name: %s", method, channelID, ts, name))
+		return e
+	}
+	return nil
+}
+
+func (cli *SlackClient) getChannel(channelID string) (*channel, error) {
+	r := conversationInfoResponse{}
+	e := cli.call("conversations.info", url.Values{
+		"channel": {channelID},
+	}, &r)
+	if e != nil {
+		e = errors.Wrap(e, fmt.Sprintf("failed to get channel info. channel: %s", channelID))
 		return nil, e
 	}
-	defer res.Body.Close()
-	byteArray, e := ioutil.ReadAll(res.Body)
+	c := r.Channel
+	return &c, nil
+}
+
+func (cli *SlackClient) getPublicChannels() ([]channel, error) {
+	channels := []channel{}
+	cursor := ""
+	for {
+		r := conversationListResponse{}
+		e := cli.call("conversations.list", url.Values{
+			"types":            {"public_channel"},
+			"exclude_archived": {"true"},
+			"limit":            {"200"},
+			"cursor":           {cursor},
+		}, &r)
+		if e != nil {
+			e = errors.Wrap(e, "failed to get channel lists.")
+			return nil, e
+		}
+		channels = append(channels, r.Channels...)
+		cursor = r.ResponseMetadata.NextCursor
+		if cursor == "" {
+			return channels, nil
+		}
+	}
+}
+
+// getUserGroups returns the user groups of the team.
+// It returns no groups on free plans, which do not have user groups.
+func (cli *SlackClient) getUserGroups() ([]userGroup, error) {
+	r := userGroupListResponse{}
+	e := cli.call("usergroups.list", url.Values{}, &r)
+	if isAPIError(e, "paid_teams_only") {
+		return []userGroup{}, nil
+	}
 	if e != nil {
-		e = errors.Wrap(e, fmt.Sprintf("failed read all. response: %+v", res))
+		e = errors.Wrap(e, "failed to get user group lists.")
 		return nil, e
 	}
-	return byteArray, nil
+	return r.UserGroups, nil
+}
+
+// getHistory returns the messages posted in the channel after oldest, newest first.
+func (cli *SlackClient) getHistory(channelID, oldest string) ([]SlackMessage, error) {
+	messages := []SlackMessage{}
+	cursor := ""
+	for {
+		r := historyResponse{}
+		e := cli.call("conversations.history", url.Values{
+			"channel":   {channelID},
+			"oldest":    {oldest},
+			"inclusive": {"false"},
+			"limit":     {"200"},
+			"cursor":    {cursor},
+		}, &r)
+		if e != nil {
+			e = errors.Wrap(e, fmt.Sprintf("failed to get history. channel: %s, oldest: %s", channelID, oldest))
+			return nil, e
+		}
+		messages = append(messages, r.Messages...)
+		cursor = r.ResponseMetadata.NextCursor
+		if !r.HasMore || cursor == "" {
+			return messages, nil
+		}
+	}
 }
@@ -0,0 +1,175 @@
+package slack
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+func TestGetAllUsersFollowsCursor(t *testing.T) {
+	requests := []string{}
+	limited := false
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal(t, "2", r.Form.Get("limit"))
+		cursor := r.Form.Get("cursor")
+		requests = append(requests, cursor)
+		switch cursor {
+		case "":
+			fmt.Fprint(w, `{"ok":true,"members":[{"id":"U1","name":"a"},{"id":"U2","name":"b"}],"response_metadata":{"next_cursor":"page2"}}`)
+		case "page2":
+			if !limited {
+				limited = true
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, `{"ok":true,"members":[{"id":"U3","name":"c"},{"id":"U4","name":"d"}],"response_metadata":{"next_cursor":"page3"}}`)
+		case "page3":
+			fmt.Fprint(w, `{"ok":true,"members":[{"id":"U5","name":"e"}],"response_metadata":{"next_cursor":""}}`)
+		default:
+			t.Errorf("unexpected cursor %s", cursor)
+		}
+	})
+	defer closeServer()
+	cli := newSlackClientForTest()
+	cli.UsersPageSize = 2
+
+	_, e := cli.getAllUsers()
+	if assert.Error(t, e) {
+		assert.Equal(t, timeline.ErrorRetryable, timeline.KindOf(e))
+	}
+
+	us, e := cli.getAllUsers()
+	if assert.NoError(t, e) {
+		ids := []string{}
+		for _, u := range us {
+			ids = append(ids, u.ID)
+		}
+		assert.Equal(t, []string{"U1", "U2", "U3", "U4", "U5"}, ids)
+	}
+	assert.Equal(t, []string{"", "page2", "", "page2", "page3"}, requests)
+}
+
+func TestGetAllUsersReturnsErrorOfPage(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("cursor") == "" {
+			fmt.Fprint(w, `{"ok":true,"members":[{"id":"U1","name":"a"}],"response_metadata":{"next_cursor":"page2"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok":false,"error":"invalid_cursor"}`)
+	})
+	defer closeServer()
+
+	cli := newSlackClientForTest()
+	_, e := cli.getAllUsers()
+	if assert.Error(t, e) {
+		assert.Contains(t, e.Error(), "invalid_cursor")
+	}
+}
+
+func TestGetUserGroupsReturnsNoGroupsOnFreePlan(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":false,"error":"paid_teams_only"}`)
+	})
+	defer closeServer()
+
+	cli := newSlackClientForTest()
+	gs, e := cli.getUserGroups()
+	if assert.NoError(t, e) {
+		assert.Empty(t, gs)
+	}
+}
+
+func TestCallReturnsAPIErrorWithWarnings(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":false,"error":"msg_too_long","warning":"missing_charset","response_metadata":{"warnings":["missing_charset"]}}`)
+	})
+	defer closeServer()
+
+	cli := newSlackClientForTest()
+	_, e := cli.postMessage("CT", "", RenderedMessage{Text: "hello"}, "a", "")
+	if assert.Error(t, e) {
+		a, ok := errors.Cause(e).(APIError)
+		if assert.True(t, ok) {
+			assert.Equal(t, APIError{
+				Method:   "chat.postMessage",
+				Code:     "msg_too_long",
+				Warnings: []string{"missing_charset", "missing_charset"},
+			}, a)
+		}
+		assert.Equal(t, timeline.ErrorSkippable, timeline.KindOf(e))
+	}
+}
+
+func TestAPIErrorKind(t *testing.T) {
+	for code, kind := range map[string]timeline.ErrorKind{
+		"ratelimited":         timeline.ErrorRetryable,
+		"internal_error":      timeline.ErrorRetryable,
+		"invalid_auth":        timeline.ErrorFatal,
+		"token_revoked":       timeline.ErrorFatal,
+		"channel_not_found":   timeline.ErrorSkippable,
+		"cant_delete_message": timeline.ErrorSkippable,
+	} {
+		assert.Equal(t, kind, APIError{Code: code}.Kind(), code)
+	}
+}
+
+func TestCallFailsOnBrokenResponseAsRetryable(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html>Bad Gateway</html>`)
+	})
+	defer closeServer()
+
+	cli := newSlackClientForTest()
+	e := cli.deleteMessage("9.0", "CT")
+	if assert.Error(t, e) {
+		assert.Equal(t, timeline.ErrorRetryable, timeline.KindOf(e))
+	}
+}
+
+func TestCallDoesNotRetryByItself(t *testing.T) {
+	calls := 0
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"ok":false,"error":"ratelimited"}`)
+	})
+	defer closeServer()
+
+	cli := newSlackClientForTest()
+	e := cli.deleteMessage("9.0", "CT")
+	if assert.Error(t, e) {
+		assert.True(t, isAPIError(e, "ratelimited"))
+		assert.Equal(t, timeline.ErrorRetryable, timeline.KindOf(e))
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestCountReaction(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal(t, "/reactions.get", r.URL.Path)
+		assert.Equal(t, "C1", r.Form.Get("channel"))
+		assert.Equal(t, "1.0", r.Form.Get("timestamp"))
+		fmt.Fprint(w, `{"ok":true,"type":"message","message":{"reactions":[{"name":"tada","count":2,"users":["U1","U2"]}]}}`)
+	})
+	defer closeServer()
+
+	cli := newSlackClientForTest()
+	n, e := cli.countReaction("C1", "1.0", "tada")
+	if assert.NoError(t, e) {
+		assert.Equal(t, 2, n)
+	}
+	n, e = cli.countReaction("C1", "1.0", "eyes")
+	if assert.NoError(t, e) {
+		assert.Equal(t, 0, n)
+	}
+}
@@ -0,0 +1,140 @@
+package slack
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+// maxRequestAge is how old a signed request can be before it is rejected as a replay.
+var maxRequestAge = 5 * time.Minute
+
+type eventsAPIRequest struct {
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// EventsAPIWorker receives events from the Slack Events API over HTTP
+// instead of connecting to RTM.
+type EventsAPIWorker struct {
+	address       string
+	path          string
+	signingSecret string
+	backfiller    Backfiller
+	logger        *log.Logger
+	now           func() time.Time
+}
+
+func NewEventsAPIWorker(address, path, signingSecret string, backfiller Backfiller, logger *log.Logger) EventsAPIWorker {
+	if path == "" {
+		path = "/"
+	}
+	return EventsAPIWorker{
+		address:       address,
+		path:          path,
+		signingSecret: signingSecret,
+		backfiller:    backfiller,
+		logger:        logger,
+		now:           time.Now,
+	}
+}
+
+func (w EventsAPIWorker) Polling(events timeline.Events) {
+	// events are acknowledged before they are handled because Slack expects
+	// a response within 3 seconds. they are handled one by one to keep the order,
+	// after the backfill so that the server is listening while it catches up.
+	queue := make(chan []byte, 100)
+	go func() {
+		backfill(w.backfiller, events.Message, w.logger)
+		for e := range queue {
+			dispatch(events, e)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle(w.path, w.handler(queue))
+	e := http.ListenAndServe(w.address, mux)
+	events.Error <- errors.Wrap(e, "events api server stopped")
+}
+
+func (w EventsAPIWorker) handler(events chan []byte) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, e := ioutil.ReadAll(r.Body)
+		if e != nil {
+			http.Error(rw, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if !w.verify(r.Header, body) {
+			w.logger.Printf("rejected a request with invalid signature from %s\n", r.RemoteAddr)
+			http.Error(rw, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		req := eventsAPIRequest{}
+		if e := json.Unmarshal(body, &req); e != nil {
+			http.Error(rw, "invalid json", http.StatusBadRequest)
+			return
+		}
+		switch req.Type {
+		case "url_verification":
+			rw.Header().Set("Content-Type", "text/plain")
+			rw.Write([]byte(req.Challenge))
+		case "event_callback":
+			// the handler does not wait for the queue, or Slack would miss the response
+			// and send the event again. it retries later when the queue is full.
+			select {
+			case events <- []byte(req.Event):
+				rw.WriteHeader(http.StatusOK)
+			default:
+				w.logger.Println("rejected an event because the queue is full")
+				http.Error(rw, "queue is full", http.StatusServiceUnavailable)
+			}
+		default:
+			rw.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+// verify checks the request signature as described in
+// https://api.slack.com/authentication/verifying-requests-from-slack
+// Every request is rejected without the signing secret.
+func (w EventsAPIWorker) verify(h http.Header, body []byte) bool {
+	if w.signingSecret == "" {
+		return false
+	}
+	ts := h.Get("X-Slack-Request-Timestamp")
+	sig := h.Get("X-Slack-Signature")
+	if ts == "" || sig == "" {
+		return false
+	}
+	sec, e := strconv.ParseInt(ts, 10, 64)
+	if e != nil {
+		return false
+	}
+	age := w.now().Sub(time.Unix(sec, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signature(w.signingSecret, ts, body)))
+}
+
+func signature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + ts + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,100 @@
+package slack
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+var signedAt = time.Unix(1600000000, 0)
+
+func newEventsAPIWorkerForTest() EventsAPIWorker {
+	w := NewEventsAPIWorker(":0", "/slack/events", "secret", nil, log.New(ioutil.Discard, "", 0))
+	w.now = func() time.Time {
+		return signedAt.Add(time.Minute)
+	}
+	return w
+}
+
+func newSignedRequest(secret, body string) *http.Request {
+	ts := strconv.FormatInt(signedAt.Unix(), 10)
+	r := httptest.NewRequest(http.MethodPost, "/slack/events", strings.NewReader(body))
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", signature(secret, ts, []byte(body)))
+	return r
+}
+
+func TestEventsAPIAnswersURLVerification(t *testing.T) {
+	w := newEventsAPIWorkerForTest()
+	rec := httptest.NewRecorder()
+	w.handler(make(chan []byte, 1)).ServeHTTP(rec, newSignedRequest("secret", `{"type":"url_verification","challenge":"abc"}`))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "abc", rec.Body.String())
+}
+
+func TestEventsAPIRejectsInvalidSignature(t *testing.T) {
+	w := newEventsAPIWorkerForTest()
+	events := make(chan []byte, 1)
+	rec := httptest.NewRecorder()
+	w.handler(events).ServeHTTP(rec, newSignedRequest("wrong", `{"type":"event_callback","event":{"type":"message"}}`))
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Len(t, events, 0)
+}
+
+func TestEventsAPIRejectsOldRequest(t *testing.T) {
+	w := newEventsAPIWorkerForTest()
+	w.now = func() time.Time {
+		return signedAt.Add(time.Hour)
+	}
+	rec := httptest.NewRecorder()
+	w.handler(make(chan []byte, 1)).ServeHTTP(rec, newSignedRequest("secret", `{"type":"url_verification","challenge":"abc"}`))
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestEventsAPIRejectsRequestWithoutSigningSecret(t *testing.T) {
+	w := newEventsAPIWorkerForTest()
+	w.signingSecret = ""
+	rec := httptest.NewRecorder()
+	w.handler(make(chan []byte, 1)).ServeHTTP(rec, newSignedRequest("", `{"type":"url_verification","challenge":"abc"}`))
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestEventsAPIQueuesMessageEvent(t *testing.T) {
+	w := newEventsAPIWorkerForTest()
+	events := make(chan []byte, 1)
+	rec := httptest.NewRecorder()
+	w.handler(events).ServeHTTP(rec, newSignedRequest("secret", `{"type":"event_callback","event":{"type":"message","user":"U1","text":"hello","channel":"C1","ts":"1.0"}}`))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	if assert.Len(t, events, 1) {
+		queued := timeline.Events{Message: make(chan *timeline.Message, 1)}
+		dispatch(queued, <-events)
+		m := <-queued.Message
+		assert.Equal(t, "hello", m.Text)
+		assert.Equal(t, "C1", m.ChannelID)
+	}
+}
+
+func TestEventsAPIRejectsEventWhenQueueIsFull(t *testing.T) {
+	w := newEventsAPIWorkerForTest()
+	events := make(chan []byte, 1)
+	events <- []byte(`{}`)
+	rec := httptest.NewRecorder()
+	w.handler(events).ServeHTTP(rec, newSignedRequest("secret", `{"type":"event_callback","event":{"type":"message","user":"U1","text":"hello","channel":"C1","ts":"1.0"}}`))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Len(t, events, 1)
+}
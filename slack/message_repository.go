@@ -1,64 +1,216 @@
 package slack
 
 import (
-	"encoding/json"
+	"fmt"
+	"time"
 
+	"github.com/ara-ta3/slack-timeline/store"
 	"github.com/ara-ta3/slack-timeline/timeline"
-	"github.com/syndtr/goleveldb/leveldb"
 )
 
-func NewMessageRepository(timelineChannelID string, s SlackClient, db leveldb.DB) MessageRepositoryOnSlack {
+func NewMessageRepository(timelineChannelID string, s SlackClient, mappings store.MappingStore, renderer Renderer) MessageRepositoryOnSlack {
 	return MessageRepositoryOnSlack{
 		timelineChannelID: timelineChannelID,
 		SlackClient:       &s,
-		db:                &db,
+		mappings:          mappings,
+		renderer:          renderer,
+		now:               time.Now,
 	}
 }
 
 type MessageRepositoryOnSlack struct {
 	timelineChannelID string
 	SlackClient       *SlackClient
-	db                *leveldb.DB
+	mappings          store.MappingStore
+	renderer          Renderer
+	now               func() time.Time
 }
 
 func (r MessageRepositoryOnSlack) FindMessageInTimeline(message timeline.Message) (*timeline.Message, error) {
-	key := message.ToKey()
-	data, err := r.db.Get([]byte(key), nil)
-	if err == leveldb.ErrNotFound {
-		return nil, nil
-	} else if err != nil {
-		return &timeline.Message{}, err
+	m, e := r.findMapping(message)
+	if e != nil || m == nil {
+		return nil, e
 	}
-	m := SlackMessage{}
-	err = json.Unmarshal(data, &m)
-	if err != nil {
-		return &timeline.Message{}, err
+	posted := timeline.NewMessage("", m.UserID, m.TimelineChannelID, m.TimelineTimeStamp)
+	return &posted, nil
+}
+
+func (r MessageRepositoryOnSlack) findMapping(message timeline.Message) (*store.Mapping, error) {
+	return r.mappings.Get(message.ChannelID, message.TimeStamp, r.destination(message))
+}
+
+// PostedTimelineChannelIDs returns the timeline channels which the message has been posted to.
+func (r MessageRepositoryOnSlack) PostedTimelineChannelIDs(message timeline.Message) ([]string, error) {
+	ms, e := r.mappings.Find(message.ChannelID, message.TimeStamp)
+	if e != nil {
+		return nil, e
+	}
+	ids := []string{}
+	for _, m := range ms {
+		ids = append(ids, m.TimelineChannelID)
+	}
+	return ids, nil
+}
+
+// mappingWriteAttempts is how many times the mapping of a posted message is written before giving up.
+var mappingWriteAttempts = 3
+
+// MappingWriteError is returned when the timeline was changed but the mapping could not be written.
+// It is skippable since doing it again would duplicate the message in the timeline,
+// and otherwise the next update or delete fixes the mapping.
+type MappingWriteError struct {
+	Mapping store.Mapping
+	Err     error
+}
+
+func (e MappingWriteError) Error() string {
+	return fmt.Sprintf("changed timeline but failed to write mapping of %s: %s", e.Mapping.Key(), e.Err.Error())
+}
+
+func (e MappingWriteError) Cause() error {
+	return e.Err
+}
+
+func (e MappingWriteError) Kind() timeline.ErrorKind {
+	return timeline.ErrorSkippable
+}
+
+// MappingReadError is returned when the mappings could not be read before posting,
+// so that the message is not posted twice. It is retryable unless the mapping is broken.
+type MappingReadError struct {
+	Err error
+}
+
+func (e MappingReadError) Error() string {
+	return "failed to read mapping before posting: " + e.Err.Error()
+}
+
+func (e MappingReadError) Cause() error {
+	return e.Err
+}
+
+func (e MappingReadError) Kind() timeline.ErrorKind {
+	// reading a broken mapping again does not help
+	if timeline.KindOf(e.Err) == timeline.ErrorSkippable {
+		return timeline.ErrorSkippable
 	}
-	msg := m.ToInternal()
-	return &msg, nil
+	return timeline.ErrorRetryable
 }
 
+// Put posts the message to the timeline and records the mapping to the post.
+// The mapping is written only when it was posted, and MappingWriteError is returned
+// if it could not be written after retries.
 func (r MessageRepositoryOnSlack) Put(u timeline.User, m timeline.Message) error {
-	if r.alreadExists(m) {
+	exists, e := r.alreadyExists(m)
+	if e != nil {
+		return MappingReadError{Err: e}
+	}
+	if exists {
 		return nil
 	}
-	t := m.Text + " (at <#" + m.ChannelID + "> )"
-	posted, e := r.SlackClient.postMessage(r.timelineChannelID, t, u.Name, u.ProfileImageURL)
+	t := r.renderer.Render(u, m)
+	threadTS, e := r.findThreadInTimeline(m)
+	if e != nil {
+		return MappingReadError{Err: e}
+	}
+	posted, e := r.SlackClient.postMessage(r.destination(m), threadTS, t, u.Name, u.ProfileImageURL)
 	if e != nil {
 		return e
 	}
-	key := m.ToKey()
-	r.db.Put([]byte(key), posted, nil)
+	mapping := store.Mapping{
+		Version:           store.MappingVersion,
+		SourceChannelID:   m.ChannelID,
+		SourceTimeStamp:   m.TimeStamp,
+		TimelineChannelID: posted.ChannelID,
+		TimelineTimeStamp: posted.TimeStamp,
+		UserID:            u.ID,
+		PostedAt:          r.now().UTC(),
+		TextHash:          store.HashText(t.Text, t.Blocks),
+	}
+	for i := 0; i < mappingWriteAttempts; i++ {
+		if e = r.mappings.Put(mapping); e == nil {
+			return nil
+		}
+	}
+	return MappingWriteError{Mapping: mapping, Err: e}
+}
+
+// Update updates the post in the timeline unless the rendered text is the same.
+func (r MessageRepositoryOnSlack) Update(u timeline.User, m timeline.Message) error {
+	mapping, e := r.findMapping(m)
+	if e != nil {
+		return e
+	}
+	if mapping == nil {
+		return timeline.MessageNotFoundError{
+			Message: m,
+		}
+	}
+	t := r.renderer.Render(u, m)
+	hash := store.HashText(t.Text, t.Blocks)
+	if hash == mapping.TextHash {
+		return nil
+	}
+	e = r.SlackClient.updateMessage(mapping.TimelineChannelID, mapping.TimelineTimeStamp, t)
+	if e != nil {
+		return e
+	}
+	mapping.TextHash = hash
+	if e := r.mappings.Put(*mapping); e != nil {
+		return MappingWriteError{Mapping: *mapping, Err: e}
+	}
 	return nil
 }
 
+// Delete deletes the post mirrored from the message and its mapping.
+// The mapping is deleted also when the post has already been deleted.
 func (r MessageRepositoryOnSlack) Delete(message timeline.Message) error {
-	_, e := r.SlackClient.deleteMessage(message.TimeStamp, message.ChannelID)
-	return e
+	mapping, e := r.findMapping(message)
+	if e != nil || mapping == nil {
+		return e
+	}
+	e = r.SlackClient.deleteMessage(mapping.TimelineTimeStamp, mapping.TimelineChannelID)
+	if e != nil && !isAPIError(e, "message_not_found") {
+		return e
+	}
+	if e := r.mappings.Delete(*mapping); e != nil {
+		return MappingWriteError{Mapping: *mapping, Err: e}
+	}
+	return nil
+}
+
+// LatestTimeStamp returns the newest timestamp of the messages from the channel
+// which have been posted to the timeline, or empty string if there is none.
+func (r MessageRepositoryOnSlack) LatestTimeStamp(channelID string) (string, error) {
+	return r.mappings.LatestTimeStamp(channelID)
+}
+
+// findThreadInTimeline returns the timestamp of the mirrored parent message
+// to post a thread reply under, or empty string if it is not a reply or the parent was not mirrored.
+func (r MessageRepositoryOnSlack) findThreadInTimeline(m timeline.Message) (string, error) {
+	if !m.IsThreadReply() {
+		return "", nil
+	}
+	parent, e := r.FindMessageInTimeline(m.ThreadParent())
+	if e != nil || parent == nil {
+		return "", e
+	}
+	return parent.TimeStamp, nil
 }
 
-func (r MessageRepositoryOnSlack) alreadExists(message timeline.Message) bool {
-	key := message.ToKey()
-	_, err := r.db.Get([]byte(key), nil)
-	return err == nil
+func (r MessageRepositoryOnSlack) alreadyExists(message timeline.Message) (bool, error) {
+	m, e := r.FindMessageInTimeline(message)
+	if e != nil {
+		return false, e
+	}
+	return m != nil, nil
+}
+
+// destination returns the channel to post the message to.
+// It is the timeline channel of the repository unless the message has one.
+func (r MessageRepositoryOnSlack) destination(m timeline.Message) string {
+	if m.TimelineChannelID != "" {
+		return m.TimelineChannelID
+	}
+	return r.timelineChannelID
 }
@@ -0,0 +1,308 @@
+package slack
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/store"
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+func newMappingStoreForTest() store.MappingStore {
+	return store.NewMappingStore(store.NewMemoryStore())
+}
+
+func TestPutThreadReplyUnderMirroredParent(t *testing.T) {
+	threadTSs := []string{}
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		threadTSs = append(threadTSs, r.Form.Get("thread_ts"))
+		fmt.Fprintf(w, `{"ok":true,"channel":"CT","ts":"9%d.0"}`, len(threadTSs))
+	})
+	defer closeServer()
+	db := newMappingStoreForTest()
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+
+	parent := timeline.Message{Text: "parent", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	reply := timeline.Message{Text: "reply", UserID: "U2", ChannelID: "C1", TimeStamp: "2.0", ThreadTimeStamp: "1.0"}
+	orphan := timeline.Message{Text: "orphan", UserID: "U2", ChannelID: "C1", TimeStamp: "4.0", ThreadTimeStamp: "3.0"}
+	for _, m := range []timeline.Message{parent, reply, orphan} {
+		assert.NoError(t, r.Put(timeline.User{}, m))
+	}
+
+	assert.Equal(t, []string{"", "91.0", ""}, threadTSs)
+}
+
+func TestPutToEachTimelineChannel(t *testing.T) {
+	posted := []string{}
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ch := r.Form.Get("channel")
+		posted = append(posted, ch)
+		fmt.Fprintf(w, `{"ok":true,"channel":"%s","ts":"9.0"}`, ch)
+	})
+	defer closeServer()
+	db := newMappingStoreForTest()
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	for _, dest := range []string{"CA", "CB", "CA"} {
+		mm := m
+		mm.TimelineChannelID = dest
+		assert.NoError(t, r.Put(timeline.User{}, mm))
+	}
+	assert.Equal(t, []string{"CA", "CB"}, posted)
+
+	m.TimelineChannelID = "CB"
+	found, e := r.FindMessageInTimeline(m)
+	if assert.NoError(t, e) && assert.NotNil(t, found) {
+		assert.Equal(t, "CB", found.ChannelID)
+	}
+	ids, e := r.PostedTimelineChannelIDs(m)
+	if assert.NoError(t, e) {
+		assert.Equal(t, []string{"CA", "CB"}, ids)
+	}
+}
+
+func TestFindMigratedMessageInTimeline(t *testing.T) {
+	b := store.NewMemoryStore()
+	b.Put("C1-1.0", []byte(`{"ok":true,"channel":"CT","ts":"9.0"}`))
+	n, e := store.Migrate(b)
+	if !assert.NoError(t, e) || !assert.Equal(t, 1, n) {
+		return
+	}
+	r := NewMessageRepository("CT", newSlackClientForTest(), store.NewMappingStore(b), PlainTextRenderer{})
+
+	m := timeline.Message{ChannelID: "C1", TimeStamp: "1.0", TimelineChannelID: "CT"}
+	found, e := r.FindMessageInTimeline(m)
+	if assert.NoError(t, e) && assert.NotNil(t, found) {
+		assert.Equal(t, "9.0", found.TimeStamp)
+	}
+	m.TimelineChannelID = "CA"
+	found, e = r.FindMessageInTimeline(m)
+	if assert.NoError(t, e) {
+		assert.Nil(t, found)
+	}
+}
+
+func TestPutDoesNotRecordFailedPost(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":false,"error":"channel_not_found"}`)
+	})
+	defer closeServer()
+	db := newMappingStoreForTest()
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	e := r.Put(timeline.User{}, m)
+	if assert.Error(t, e) {
+		assert.Contains(t, e.Error(), "channel_not_found")
+	}
+	found, e := r.FindMessageInTimeline(m)
+	if assert.NoError(t, e) {
+		assert.Nil(t, found)
+	}
+}
+
+type renderedMessageForTest RenderedMessage
+
+func (r renderedMessageForTest) Render(u timeline.User, m timeline.Message) RenderedMessage {
+	return RenderedMessage(r)
+}
+
+func TestPutFallsBackOnInvalidBlocks(t *testing.T) {
+	blocks := []string{}
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		blocks = append(blocks, r.Form.Get("blocks"))
+		if len(blocks) == 1 {
+			fmt.Fprint(w, `{"ok":false,"error":"invalid_blocks"}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true,"channel":"CT","ts":"9.0"}`)
+	})
+	defer closeServer()
+	rendered := renderedMessageForTest{Text: "hello", Blocks: "image", FallbackBlocks: "link"}
+	r := NewMessageRepository("CT", newSlackClientForTest(), newMappingStoreForTest(), rendered)
+
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	assert.NoError(t, r.Put(timeline.User{}, m))
+	assert.Equal(t, []string{"image", "link"}, blocks)
+}
+
+func TestPutRecordsMappingAndUpdateSkipsSameText(t *testing.T) {
+	calls := []string{}
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		fmt.Fprint(w, `{"ok":true,"channel":"CT","ts":"9.0"}`)
+	})
+	defer closeServer()
+	db := newMappingStoreForTest()
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+	postedAt := time.Unix(1600000000, 0).UTC()
+	r.now = func() time.Time { return postedAt }
+
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	assert.NoError(t, r.Put(timeline.User{ID: "U1"}, m))
+	mapping, e := db.Get("C1", "1.0", "CT")
+	if assert.NoError(t, e) {
+		assert.Equal(t, &store.Mapping{
+			Version:           store.MappingVersion,
+			SourceChannelID:   "C1",
+			SourceTimeStamp:   "1.0",
+			TimelineChannelID: "CT",
+			TimelineTimeStamp: "9.0",
+			UserID:            "U1",
+			PostedAt:          postedAt,
+			TextHash:          store.HashText("hello (at <#C1> )", ""),
+		}, mapping)
+	}
+
+	assert.NoError(t, r.Update(timeline.User{ID: "U1"}, m))
+	m.Text = "edited"
+	assert.NoError(t, r.Update(timeline.User{ID: "U1"}, m))
+	assert.Equal(t, []string{"/chat.postMessage", "/chat.update"}, calls)
+}
+
+func TestDeleteDropsMapping(t *testing.T) {
+	deleted := []string{}
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path == "/chat.delete" {
+			deleted = append(deleted, r.Form.Get("channel")+"-"+r.Form.Get("ts"))
+		}
+		fmt.Fprint(w, `{"ok":true,"channel":"CT","ts":"9.0"}`)
+	})
+	defer closeServer()
+	db := newMappingStoreForTest()
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	assert.NoError(t, r.Put(timeline.User{}, m))
+	assert.NoError(t, r.Delete(m))
+
+	assert.Equal(t, []string{"CT-9.0"}, deleted)
+	mapping, e := db.Get("C1", "1.0", "CT")
+	if assert.NoError(t, e) {
+		assert.Nil(t, mapping)
+	}
+}
+
+func TestPutDoesNotWriteMappingOnAPIError(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":false,"error":"not_in_channel"}`)
+	})
+	defer closeServer()
+	db := newMappingStoreForTest()
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	e := r.Put(timeline.User{}, m)
+	if assert.Error(t, e) {
+		assert.Equal(t, timeline.ErrorSkippable, timeline.KindOf(e))
+	}
+	mapping, _ := db.Get("C1", "1.0", "CT")
+	assert.Nil(t, mapping)
+}
+
+func TestDeleteKeepsMappingUnlessPostIsGone(t *testing.T) {
+	code := "cant_delete_message"
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chat.delete" {
+			fmt.Fprintf(w, `{"ok":false,"error":"%s"}`, code)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true,"channel":"CT","ts":"9.0"}`)
+	})
+	defer closeServer()
+	db := newMappingStoreForTest()
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	assert.NoError(t, r.Put(timeline.User{}, m))
+	assert.Error(t, r.Delete(m))
+	mapping, _ := db.Get("C1", "1.0", "CT")
+	assert.NotNil(t, mapping)
+
+	code = "message_not_found"
+	assert.NoError(t, r.Delete(m))
+	mapping, _ = db.Get("C1", "1.0", "CT")
+	assert.Nil(t, mapping)
+}
+
+type failingMappingStore struct {
+	store.MappingStore
+	failures *int
+	getError error
+}
+
+func (s failingMappingStore) Get(sourceChannelID, sourceTimeStamp, timelineChannelID string) (*store.Mapping, error) {
+	if s.getError != nil {
+		return nil, s.getError
+	}
+	return s.MappingStore.Get(sourceChannelID, sourceTimeStamp, timelineChannelID)
+}
+
+func (s failingMappingStore) Put(m store.Mapping) error {
+	if *s.failures > 0 {
+		*s.failures--
+		return errors.New("disk full")
+	}
+	return s.MappingStore.Put(m)
+}
+
+func TestPutRetriesWritingMappingOfPostedMessage(t *testing.T) {
+	posts := 0
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		fmt.Fprint(w, `{"ok":true,"channel":"CT","ts":"9.0"}`)
+	})
+	defer closeServer()
+	failures := 2
+	db := failingMappingStore{MappingStore: newMappingStoreForTest(), failures: &failures}
+	r := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{})
+
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+	assert.NoError(t, r.Put(timeline.User{}, m))
+	mapping, _ := db.Get("C1", "1.0", "CT")
+	assert.NotNil(t, mapping)
+
+	failures = mappingWriteAttempts
+	m.TimeStamp = "2.0"
+	e := r.Put(timeline.User{}, m)
+	if assert.Error(t, e) {
+		assert.IsType(t, MappingWriteError{}, e)
+		assert.Equal(t, timeline.ErrorSkippable, timeline.KindOf(e))
+	}
+	assert.Equal(t, 2, posts)
+}
+
+func TestPutDoesNotPostWhenMappingCannotBeRead(t *testing.T) {
+	posts := 0
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		fmt.Fprint(w, `{"ok":true,"channel":"CT","ts":"9.0"}`)
+	})
+	defer closeServer()
+	failures := 0
+	m := timeline.Message{Text: "hello", UserID: "U1", ChannelID: "C1", TimeStamp: "1.0"}
+
+	db := failingMappingStore{MappingStore: newMappingStoreForTest(), failures: &failures, getError: errors.New("i/o error")}
+	e := NewMessageRepository("CT", newSlackClientForTest(), db, PlainTextRenderer{}).Put(timeline.User{}, m)
+	if assert.Error(t, e) {
+		assert.Equal(t, timeline.ErrorRetryable, timeline.KindOf(e))
+	}
+
+	b := store.NewMemoryStore()
+	b.Put("mapping:C1-1.0-CT", []byte(`broken`))
+	e = NewMessageRepository("CT", newSlackClientForTest(), store.NewMappingStore(b), PlainTextRenderer{}).Put(timeline.User{}, m)
+	if assert.Error(t, e) {
+		assert.Equal(t, timeline.ErrorSkippable, timeline.KindOf(e))
+	}
+	assert.Equal(t, 0, posts)
+}
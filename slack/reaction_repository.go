@@ -0,0 +1,26 @@
+package slack
+
+import "github.com/ara-ta3/slack-timeline/timeline"
+
+func NewReactionRepository(s SlackClient) ReactionRepositoryOnSlack {
+	return ReactionRepositoryOnSlack{
+		SlackClient: &s,
+	}
+}
+
+type ReactionRepositoryOnSlack struct {
+	SlackClient *SlackClient
+}
+
+func (r ReactionRepositoryOnSlack) Add(m timeline.Message, name string) error {
+	return r.SlackClient.addReaction(m.ChannelID, m.TimeStamp, name)
+}
+
+func (r ReactionRepositoryOnSlack) Remove(m timeline.Message, name string) error {
+	return r.SlackClient.removeReaction(m.ChannelID, m.TimeStamp, name)
+}
+
+// Count returns the number of users who reacted to the source message with the reaction.
+func (r ReactionRepositoryOnSlack) Count(m timeline.Message, name string) (int, error) {
+	return r.SlackClient.countReaction(m.ChannelID, m.TimeStamp, name)
+}
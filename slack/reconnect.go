@@ -0,0 +1,85 @@
+package slack
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	defaultMaxRetries      = 10
+	defaultInitialInterval = 1 * time.Second
+	defaultMaxInterval     = 2 * time.Minute
+)
+
+type ReconnectPolicy struct {
+	MaxRetries      int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+func NewReconnectPolicy(maxRetries int, initialInterval, maxInterval time.Duration) ReconnectPolicy {
+	p := ReconnectPolicy{
+		MaxRetries:      maxRetries,
+		InitialInterval: initialInterval,
+		MaxInterval:     maxInterval,
+	}
+	if p.MaxRetries <= 0 {
+		p.MaxRetries = defaultMaxRetries
+	}
+	if p.InitialInterval <= 0 {
+		p.InitialInterval = defaultInitialInterval
+	}
+	if p.MaxInterval <= 0 {
+		p.MaxInterval = defaultMaxInterval
+	}
+	return p
+}
+
+// Backoff returns how long to wait before the n-th reconnection attempt.
+// The interval doubles on every attempt up to MaxInterval and a random jitter
+// of up to half of it is subtracted so that many clients do not reconnect at once.
+func (p ReconnectPolicy) Backoff(n int) time.Duration {
+	d := p.InitialInterval
+	for i := 1; i < n && d < p.MaxInterval; i++ {
+		d *= 2
+	}
+	if d > p.MaxInterval {
+		d = p.MaxInterval
+	}
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// keepConnected calls connect again and again until it fails more than MaxRetries times in a row.
+// connect returns the number of events received and the error which closed the connection.
+// The failures are counted from zero again only after a connection received events, so that
+// connections which drop right after they are opened still use up the retries.
+// The error is nil when the server asked to reconnect, in which case it reconnects without waiting.
+func (p ReconnectPolicy) keepConnected(name string, logger *log.Logger, sleep func(time.Duration), connect func() (int, error)) error {
+	failures := 0
+	for {
+		n, e := connect()
+		if n > 0 {
+			failures = 0
+		}
+		if e == nil {
+			logger.Printf("%s asked to reconnect. reconnecting\n", name)
+			continue
+		}
+
+		failures++
+		if failures > p.MaxRetries {
+			return errors.Wrap(e, fmt.Sprintf("gave up reconnecting to %s after %d attempts", name, failures))
+		}
+		wait := p.Backoff(failures)
+		logger.Printf("%+v\nreconnecting to %s in %+v (attempt %d/%d)\n", e, name, wait, failures, p.MaxRetries)
+		sleep(wait)
+	}
+}
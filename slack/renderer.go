@@ -0,0 +1,209 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+// maxSectionTextLength is the limit of the text in a section block.
+var maxSectionTextLength = 3000
+
+// maxImageTitleLength is the limit of the title of an image block.
+var maxImageTitleLength = 2000
+
+// Renderer builds what is posted to the timeline from the message.
+type Renderer interface {
+	Render(u timeline.User, m timeline.Message) RenderedMessage
+}
+
+// RenderedMessage is what is posted by chat.postMessage or chat.update.
+// Text is the fallback for notifications when Blocks is set.
+// FallbackBlocks are posted instead of Blocks if Slack rejects them, which happens
+// when it cannot show the images in them.
+type RenderedMessage struct {
+	Text           string
+	Blocks         string
+	FallbackBlocks string
+}
+
+// PlainTextRenderer posts the text with the channel it came from.
+type PlainTextRenderer struct{}
+
+func (r PlainTextRenderer) Render(u timeline.User, m timeline.Message) RenderedMessage {
+	return RenderedMessage{
+		Text: m.Text + attachmentsText(m.Attachments) + fileLinks(m.Files) + " (at <#" + m.ChannelID + "> )",
+	}
+}
+
+// attachmentsText returns the summaries of the attachments, each of which starts on a new line.
+func attachmentsText(as []timeline.Attachment) string {
+	s := ""
+	for _, a := range as {
+		if t := a.Summary(); t != "" {
+			s += "\n" + t
+		}
+	}
+	return s
+}
+
+// fileLinks returns the links to the files, each of which is on its own line.
+func fileLinks(files []timeline.File) string {
+	s := ""
+	for _, f := range files {
+		s += "\n" + fileLink(f)
+	}
+	return s
+}
+
+func fileLink(f timeline.File) string {
+	return fmt.Sprintf("<%s|%s>", f.Permalink, f.LinkLabel())
+}
+
+// BlockKitRenderer posts the text in a section block and the channel, the author
+// and the link to the original message in a context block.
+// Images are previewed in image blocks, and other files are posted as links.
+type BlockKitRenderer struct {
+	SlackClient *SlackClient
+	permalinks  *permalinkCache
+	logger      *log.Logger
+}
+
+func NewBlockKitRenderer(s SlackClient, logger *log.Logger) BlockKitRenderer {
+	return BlockKitRenderer{
+		SlackClient: &s,
+		permalinks:  &permalinkCache{},
+		logger:      logger,
+	}
+}
+
+// permalinkCache keeps the permalink of the last message, so that it is fetched only once
+// while the message is rendered for each timeline channel.
+type permalinkCache struct {
+	mutex sync.Mutex
+	key   string
+	link  string
+}
+
+func (c *permalinkCache) get(s *SlackClient, channelID, ts string) (string, error) {
+	if c == nil {
+		return s.getPermalink(channelID, ts)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := channelID + "-" + ts
+	if c.key == key {
+		return c.link, nil
+	}
+	link, e := s.getPermalink(channelID, ts)
+	if e != nil {
+		return "", e
+	}
+	c.key, c.link = key, link
+	return link, nil
+}
+
+type block struct {
+	Type      string     `json:"type"`
+	Text      *element   `json:"text,omitempty"`
+	Elements  []element  `json:"elements,omitempty"`
+	SlackFile *slackFile `json:"slack_file,omitempty"`
+	AltText   string     `json:"alt_text,omitempty"`
+	Title     *element   `json:"title,omitempty"`
+}
+
+// slackFile refers to a file uploaded to Slack, which image blocks can show without its public URL.
+type slackFile struct {
+	ID string `json:"id"`
+}
+
+type element struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+func (r BlockKitRenderer) Render(u timeline.User, m timeline.Message) RenderedMessage {
+	context := r.contextBlock(u, m)
+	rendered := RenderedMessage{
+		Text:   PlainTextRenderer{}.Render(u, m).Text,
+		Blocks: marshalBlocks(append(contentBlocks(m, true), context)),
+	}
+	for _, f := range m.Files {
+		if f.IsImage() {
+			rendered.FallbackBlocks = marshalBlocks(append(contentBlocks(m, false), context))
+			break
+		}
+	}
+	return rendered
+}
+
+// contentBlocks returns the blocks of the text, the attachments and the files.
+// Images are previewed if previewImages is true, otherwise they are posted as links like other files.
+func contentBlocks(m timeline.Message, previewImages bool) []block {
+	blocks := []block{}
+	if m.Text != "" {
+		blocks = append(blocks, block{
+			Type: "section",
+			Text: &element{Type: "mrkdwn", Text: truncate(m.Text, maxSectionTextLength)},
+		})
+	}
+	for _, a := range m.Attachments {
+		if t := a.Summary(); t != "" {
+			blocks = append(blocks, block{
+				Type: "section",
+				Text: &element{Type: "mrkdwn", Text: truncate(t, maxSectionTextLength)},
+			})
+		}
+	}
+	for _, f := range m.Files {
+		if previewImages && f.IsImage() {
+			blocks = append(blocks, block{
+				Type:      "image",
+				SlackFile: &slackFile{ID: f.ID},
+				AltText:   f.Label(),
+				Title:     &element{Type: "plain_text", Text: truncate(f.Label(), maxImageTitleLength)},
+			})
+			continue
+		}
+		blocks = append(blocks, block{
+			Type: "section",
+			Text: &element{Type: "mrkdwn", Text: ":page_facing_up: " + fileLink(f)},
+		})
+	}
+	return blocks
+}
+
+// contextBlock returns the block of the channel, the author and the link to the original message.
+func (r BlockKitRenderer) contextBlock(u timeline.User, m timeline.Message) block {
+	context := []element{}
+	if u.ProfileImageURL != "" {
+		context = append(context, element{Type: "image", ImageURL: u.ProfileImageURL, AltText: u.Name})
+	}
+	source := fmt.Sprintf("<#%s> | %s", m.ChannelID, u.Name)
+	permalink, e := r.permalinks.get(r.SlackClient, m.ChannelID, m.TimeStamp)
+	if e != nil {
+		r.logger.Printf("failed to get permalink of %s: %+v\n", m.ToKey(), e)
+	} else {
+		source += fmt.Sprintf(" | <%s|original message>", permalink)
+	}
+	context = append(context, element{Type: "mrkdwn", Text: source})
+	return block{Type: "context", Elements: context}
+}
+
+func marshalBlocks(blocks []block) string {
+	b, _ := json.Marshal(blocks)
+	return string(b)
+}
+
+func truncate(s string, n int) string {
+	rs := []rune(s)
+	if len(rs) <= n {
+		return s
+	}
+	return string(rs[:n-1]) + "…"
+}
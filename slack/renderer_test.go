@@ -0,0 +1,152 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+func TestPlainTextRenderer(t *testing.T) {
+	m := timeline.Message{Text: "hello", ChannelID: "C1"}
+	r := PlainTextRenderer{}.Render(timeline.User{}, m)
+	assert.Equal(t, "hello (at <#C1> )", r.Text)
+	assert.Equal(t, "", r.Blocks)
+}
+
+func TestBlockKitRenderer(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal(t, "/chat.getPermalink", r.URL.Path)
+		fmt.Fprintf(w, `{"ok":true,"permalink":"https://example.slack.com/archives/%s/p%s"}`, r.Form.Get("channel"), strings.Replace(r.Form.Get("message_ts"), ".", "", 1))
+	})
+	defer closeServer()
+
+	u := timeline.User{Name: "dark", ProfileImageURL: "https://example.com/dark.png"}
+	m := timeline.Message{Text: "hello", ChannelID: "C1", TimeStamp: "1.5"}
+	r := NewBlockKitRenderer(newSlackClientForTest(), log.New(ioutil.Discard, "", 0)).Render(u, m)
+
+	assert.Equal(t, "hello (at <#C1> )", r.Text)
+	blocks := []block{}
+	if assert.NoError(t, json.Unmarshal([]byte(r.Blocks), &blocks)) && assert.Len(t, blocks, 2) {
+		assert.Equal(t, "section", blocks[0].Type)
+		assert.Equal(t, "hello", blocks[0].Text.Text)
+		assert.Equal(t, "context", blocks[1].Type)
+		if assert.Len(t, blocks[1].Elements, 2) {
+			assert.Equal(t, "https://example.com/dark.png", blocks[1].Elements[0].ImageURL)
+			assert.Equal(t, "<#C1> | dark | <https://example.slack.com/archives/C1/p15|original message>", blocks[1].Elements[1].Text)
+		}
+	}
+}
+
+func TestBlockKitRendererGetsPermalinkOncePerMessage(t *testing.T) {
+	calls := 0
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, `{"ok":true,"permalink":"https://example.slack.com/p1"}`)
+	})
+	defer closeServer()
+
+	r := NewBlockKitRenderer(newSlackClientForTest(), log.New(ioutil.Discard, "", 0))
+	m := timeline.Message{Text: "hello", ChannelID: "C1", TimeStamp: "1.5"}
+	for _, dest := range []string{"CA", "CB"} {
+		m.TimelineChannelID = dest
+		r.Render(timeline.User{}, m)
+	}
+	assert.Equal(t, 1, calls)
+
+	m.TimeStamp = "2.5"
+	r.Render(timeline.User{}, m)
+	assert.Equal(t, 2, calls)
+}
+
+func TestBlockKitRendererWithoutPermalink(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":false,"error":"message_not_found"}`)
+	})
+	defer closeServer()
+
+	m := timeline.Message{Text: "", ChannelID: "C1", TimeStamp: "1.5"}
+	r := NewBlockKitRenderer(newSlackClientForTest(), log.New(ioutil.Discard, "", 0)).Render(timeline.User{Name: "dark"}, m)
+
+	blocks := []block{}
+	if assert.NoError(t, json.Unmarshal([]byte(r.Blocks), &blocks)) && assert.Len(t, blocks, 1) {
+		assert.Equal(t, "<#C1> | dark", blocks[0].Elements[0].Text)
+	}
+}
+
+func TestRenderersIncludeFiles(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":false,"error":"message_not_found"}`)
+	})
+	defer closeServer()
+
+	raw := `{"type":"message","subtype":"file_share","user":"U1","text":"","channel":"C1","ts":"1.5","files":[` +
+		`{"id":"F1","name":"cat.png","title":"Cat","mimetype":"image/png","permalink":"https://example.slack.com/files/F1","thumb_360":"https://files.example.com/cat_360.png"},` +
+		`{"id":"F2","name":"notes.pdf","title":"","mimetype":"application/pdf","permalink":"https://example.slack.com/files/F2"}]}`
+	s := SlackMessage{}
+	if !assert.NoError(t, json.Unmarshal([]byte(raw), &s)) {
+		return
+	}
+	m := s.ToInternal()
+	if assert.Len(t, m.Files, 2) {
+		assert.Equal(t, "https://files.example.com/cat_360.png", m.Files[0].Thumbnail)
+		assert.Equal(t, "notes.pdf", m.Files[1].Label())
+	}
+
+	links := "\n<https://example.slack.com/files/F1|Cat>\n<https://example.slack.com/files/F2|notes.pdf> (at <#C1> )"
+	assert.Equal(t, links, PlainTextRenderer{}.Render(timeline.User{}, m).Text)
+
+	tr, e := newTemplateRendererForTest("", nil)
+	if assert.NoError(t, e) {
+		assert.Equal(t, links, tr.Render(timeline.User{}, m).Text)
+	}
+
+	r := NewBlockKitRenderer(newSlackClientForTest(), log.New(ioutil.Discard, "", 0)).Render(timeline.User{}, m)
+	blocks := []block{}
+	if assert.NoError(t, json.Unmarshal([]byte(r.Blocks), &blocks)) && assert.Len(t, blocks, 3) {
+		assert.Equal(t, "image", blocks[0].Type)
+		assert.Equal(t, "F1", blocks[0].SlackFile.ID)
+		assert.Equal(t, "section", blocks[1].Type)
+		assert.Contains(t, blocks[1].Text.Text, "<https://example.slack.com/files/F2|notes.pdf>")
+	}
+	fallback := []block{}
+	if assert.NoError(t, json.Unmarshal([]byte(r.FallbackBlocks), &fallback)) && assert.Len(t, fallback, 3) {
+		assert.Equal(t, "section", fallback[0].Type)
+		assert.Equal(t, ":page_facing_up: <https://example.slack.com/files/F1|Cat>", fallback[0].Text.Text)
+	}
+}
+
+func TestRenderersEscapeFileLabels(t *testing.T) {
+	m := timeline.Message{ChannelID: "C1", Files: []timeline.File{
+		{Name: "a.txt", Title: "a|b>&c", Permalink: "https://example.slack.com/files/F1"},
+	}}
+	assert.Equal(t, "\n<https://example.slack.com/files/F1|a¦b&gt;&amp;c> (at <#C1> )", PlainTextRenderer{}.Render(timeline.User{}, m).Text)
+}
+
+func TestBotMessageWithAttachments(t *testing.T) {
+	raw := `{"type":"message","subtype":"bot_message","bot_id":"BCI","username":"CI","icons":{"image_48":"https://example.com/ci48.png"},"text":"","channel":"C1","ts":"1.5",` +
+		`"attachments":[{"title":"build #1","title_link":"https://ci.example.com/1","text":"passed","fallback":"build #1 passed"},{"fallback":"only fallback"}]}`
+	s := SlackMessage{}
+	if !assert.NoError(t, json.Unmarshal([]byte(raw), &s)) {
+		return
+	}
+	assert.True(t, s.IsMessageToPost())
+	m := s.ToInternal()
+	assert.Equal(t, "CI", m.BotName)
+	assert.Equal(t, "https://example.com/ci48.png", m.BotIconURL)
+
+	expected := "\n*<https://ci.example.com/1|build #1>*\npassed\nonly fallback (at <#C1> )"
+	assert.Equal(t, expected, PlainTextRenderer{}.Render(timeline.User{}, m).Text)
+	tr, e := newTemplateRendererForTest("", nil)
+	if assert.NoError(t, e) {
+		assert.Equal(t, expected, tr.Render(timeline.User{}, m).Text)
+	}
+}
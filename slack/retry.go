@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ara-ta3/retry"
@@ -72,8 +73,14 @@ func (r *SlackRetryAble) GetRequest(url string) (*http.Response, error) {
 	})
 }
 
-func (r *SlackRetryAble) PostReqest(url string, params url.Values) (*http.Response, error) {
+func (r *SlackRetryAble) PostReqestWithToken(url string, params url.Values, token string) (*http.Response, error) {
 	return r.request(func() (*http.Response, error) {
-		return http.PostForm(url, params)
+		req, e := http.NewRequest(http.MethodPost, url, strings.NewReader(params.Encode()))
+		if e != nil {
+			return nil, e
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		return http.DefaultClient.Do(req)
 	})
 }
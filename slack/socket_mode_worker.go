@@ -0,0 +1,129 @@
+package slack
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+type SocketModeClient interface {
+	OpenSocketMode(appToken string) (SocketModeConnection, error)
+}
+
+type SocketModeConnection interface {
+	Read() ([]byte, error)
+	Write(b []byte) error
+	Close() error
+}
+
+type SlackSocketModeConnection struct {
+	ws *websocket.Conn
+}
+
+func (c SlackSocketModeConnection) Read() ([]byte, error) {
+	var msg []byte
+	e := websocket.Message.Receive(c.ws, &msg)
+	if e != nil {
+		return nil, e
+	}
+	return msg, nil
+}
+
+func (c SlackSocketModeConnection) Write(b []byte) error {
+	return websocket.Message.Send(c.ws, string(b))
+}
+
+func (c SlackSocketModeConnection) Close() error {
+	return c.ws.Close()
+}
+
+type socketModeEnvelope struct {
+	EnvelopeID string          `json:"envelope_id"`
+	Type       string          `json:"type"`
+	Reason     string          `json:"reason"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type socketModeAck struct {
+	EnvelopeID string `json:"envelope_id"`
+}
+
+// SocketModeWorker receives events from the Events API through a Socket Mode
+// websocket, so that it works without accepting inbound HTTP requests.
+type SocketModeWorker struct {
+	client     SocketModeClient
+	appToken   string
+	backfiller Backfiller
+	reconnect  ReconnectPolicy
+	logger     *log.Logger
+	sleep      func(time.Duration)
+}
+
+func NewSocketModeWorker(client SocketModeClient, appToken string, backfiller Backfiller, reconnect ReconnectPolicy, logger *log.Logger) SocketModeWorker {
+	return SocketModeWorker{
+		client:     client,
+		appToken:   appToken,
+		backfiller: backfiller,
+		reconnect:  reconnect,
+		logger:     logger,
+		sleep:      time.Sleep,
+	}
+}
+
+func (w SocketModeWorker) Polling(events timeline.Events) {
+	events.Error <- w.reconnect.keepConnected("slack socket mode", w.logger, w.sleep, func() (int, error) {
+		con, e := w.client.OpenSocketMode(w.appToken)
+		if e != nil {
+			return 0, errors.Wrap(e, "failed to connecting to slack socket mode")
+		}
+		defer con.Close()
+		backfill(w.backfiller, events.Message, w.logger)
+		n, e := w.receive(con, events)
+		if e != nil {
+			return n, errors.Wrap(e, "failed to reading from slack socket mode")
+		}
+		return n, nil
+	})
+}
+
+// receive reads envelopes from con until the connection fails or Slack sends disconnect.
+// Every envelope is acknowledged before it is handled, otherwise Slack sends it again.
+// It returns the number of events received like SlackTimelineWorker.receive.
+func (w SocketModeWorker) receive(con SocketModeConnection, events timeline.Events) (int, error) {
+	n := 0
+	for {
+		received, e := con.Read()
+		if e != nil {
+			return n, e
+		}
+		env := socketModeEnvelope{}
+		if e := json.Unmarshal(received, &env); e != nil {
+			continue
+		}
+		if env.EnvelopeID != "" {
+			ack, _ := json.Marshal(socketModeAck{EnvelopeID: env.EnvelopeID})
+			if e := con.Write(ack); e != nil {
+				return n, errors.Wrap(e, "failed to acknowledge envelope "+env.EnvelopeID)
+			}
+		}
+		switch env.Type {
+		case "disconnect":
+			w.logger.Printf("slack socket mode sent disconnect. reason: %s\n", env.Reason)
+			return n, nil
+		case "events_api":
+			n++
+			req := eventsAPIRequest{}
+			if e := json.Unmarshal(env.Payload, &req); e != nil {
+				continue
+			}
+			if req.Type == "event_callback" {
+				dispatch(events, req.Event)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package slack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+func envelope(id, text string) string {
+	return fmt.Sprintf(`{"envelope_id":"%s","type":"events_api","accepts_response_payload":false,"payload":{"type":"event_callback","event":{"type":"message","user":"U1","text":"%s","channel":"C1","ts":"1.0"}}}`, id, text)
+}
+
+func TestSocketModeAcknowledgesEnvelopesAndReconnectsOnDisconnect(t *testing.T) {
+	sessions := [][]string{
+		{`{"type":"hello"}`, envelope("e1", "first"), `{"type":"disconnect","reason":"refresh_requested"}`},
+		{`{"type":"hello"}`, envelope("e2", "second")},
+	}
+	var mu sync.Mutex
+	acks := []string{}
+	opened := 0
+	authorizations := []string{}
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		if opened >= len(sessions) {
+			fmt.Fprint(w, `{"ok":false,"error":"invalid_auth"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"ok":true,"url":"ws%s/socket?session=%d"}`, strings.TrimPrefix(server.URL, "http"), opened)
+		opened++
+	})
+	mux.Handle("/socket", websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		var session int
+		fmt.Sscanf(ws.Request().URL.Query().Get("session"), "%d", &session)
+		for _, frame := range sessions[session] {
+			if e := websocket.Message.Send(ws, frame); e != nil {
+				return
+			}
+			if strings.Contains(frame, "envelope_id") {
+				var ack string
+				if e := websocket.Message.Receive(ws, &ack); e != nil {
+					return
+				}
+				mu.Lock()
+				acks = append(acks, ack)
+				mu.Unlock()
+			}
+		}
+	}))
+	orig := slackAPIEndpoint
+	slackAPIEndpoint = server.URL + "/"
+	defer func() { slackAPIEndpoint = orig }()
+
+	w := NewSocketModeWorker(
+		newSlackClientForTest(),
+		"xapp-token",
+		nil,
+		NewReconnectPolicy(1, time.Millisecond, time.Millisecond),
+		log.New(ioutil.Discard, "", 0),
+	)
+	w.sleep = func(time.Duration) {}
+
+	events := timeline.NewEvents()
+	go w.Polling(events)
+	texts := []string{}
+	var err error
+	for err == nil {
+		select {
+		case m := <-events.Message:
+			texts = append(texts, m.Text)
+		case err = <-events.Error:
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"first", "second"}, texts)
+	assert.Equal(t, []string{`{"envelope_id":"e1"}`, `{"envelope_id":"e2"}`}, acks)
+	assert.Equal(t, 3, len(authorizations))
+	assert.Equal(t, "Bearer xapp-token", authorizations[0])
+}
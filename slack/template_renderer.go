@@ -0,0 +1,173 @@
+package slack
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+// DefaultTemplate renders the same text as PlainTextRenderer.
+var DefaultTemplate = "{{.Text}}{{range .Attachments}}{{with .Summary}}\n{{.}}{{end}}{{end}}{{range .Files}}\n<{{.Permalink}}|{{.LinkLabel}}>{{end}} (at <#{{.ChannelID}}> )"
+
+// TemplateRenderer renders messages with text/template.
+// The template of the route which posts the message to the timeline channel is used if there is,
+// otherwise the default one.
+type TemplateRenderer struct {
+	defaultTemplate   *template.Template
+	routeTemplates    []*template.Template
+	router            timeline.Router
+	SlackClient       *SlackClient
+	permalinks        *permalinkCache
+	channelRepository timeline.ChannelRepository
+	logger            *log.Logger
+}
+
+// NewTemplateRenderer parses and checks the templates so that broken templates fail at startup.
+// routeTemplates are the templates for each route of router in the same order, and empty ones are not used.
+func NewTemplateRenderer(
+	defaultTemplate string,
+	routeTemplates []string,
+	router timeline.Router,
+	s SlackClient,
+	channelRepository timeline.ChannelRepository,
+	logger *log.Logger,
+) (TemplateRenderer, error) {
+	if defaultTemplate == "" {
+		defaultTemplate = DefaultTemplate
+	}
+	d, e := parseTemplate("default", defaultTemplate)
+	if e != nil {
+		return TemplateRenderer{}, e
+	}
+	ts := make([]*template.Template, len(routeTemplates))
+	for i, t := range routeTemplates {
+		if t == "" {
+			continue
+		}
+		ts[i], e = parseTemplate(fmt.Sprintf("route %d", i), t)
+		if e != nil {
+			return TemplateRenderer{}, e
+		}
+	}
+	return TemplateRenderer{
+		defaultTemplate:   d,
+		routeTemplates:    ts,
+		router:            router,
+		SlackClient:       &s,
+		permalinks:        &permalinkCache{},
+		channelRepository: channelRepository,
+		logger:            logger,
+	}, nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, e := template.New(name).Parse(text)
+	if e != nil {
+		return nil, errors.Wrap(e, "failed to parse template "+name)
+	}
+	// execute it once with a sample to find unknown fields
+	sample := TemplateData{
+		Text:      "sample",
+		ChannelID: "C00000000",
+		UserName:  "sample",
+		TimeStamp: "1500000000.000000",
+		Files:     []timeline.File{{Name: "sample.png", MimeType: "image/png"}},
+	}
+	if e := t.Execute(&bytes.Buffer{}, sample); e != nil {
+		return nil, errors.Wrap(e, "failed to execute template "+name)
+	}
+	return t, nil
+}
+
+func (r TemplateRenderer) Render(u timeline.User, m timeline.Message) RenderedMessage {
+	t := r.templateOf(m)
+	data := TemplateData{
+		Text:            m.Text,
+		ChannelID:       m.ChannelID,
+		UserName:        u.Name,
+		TimeStamp:       m.TimeStamp,
+		ThreadTimeStamp: m.ThreadTimeStamp,
+		IsThreadReply:   m.IsThreadReply(),
+		Attachments:     m.Attachments,
+		Files:           m.Files,
+		renderer:        &r,
+	}
+	b := &bytes.Buffer{}
+	if e := t.Execute(b, data); e != nil {
+		r.logger.Printf("failed to render %s with template %s: %+v\n", m.ToKey(), t.Name(), e)
+		return PlainTextRenderer{}.Render(u, m)
+	}
+	return RenderedMessage{
+		Text: b.String(),
+	}
+}
+
+// templateOf returns the template of the route which posts the message, or the default one.
+func (r TemplateRenderer) templateOf(m timeline.Message) *template.Template {
+	i, e := r.router.RouteOf(m.ChannelID, m.TimelineChannelID)
+	if e != nil {
+		r.logger.Printf("failed to find route of %s to %s: %+v\n", m.ToKey(), m.TimelineChannelID, e)
+		return r.defaultTemplate
+	}
+	if i < 0 || i >= len(r.routeTemplates) || r.routeTemplates[i] == nil {
+		return r.defaultTemplate
+	}
+	return r.routeTemplates[i]
+}
+
+// TemplateData is given to templates.
+// ChannelName and Permalink call Slack API only when the template uses them.
+type TemplateData struct {
+	Text            string
+	ChannelID       string
+	UserName        string
+	TimeStamp       string
+	ThreadTimeStamp string
+	IsThreadReply   bool
+	// Attachments have Pretext, Title, TitleLink, Text, Fallback and Summary.
+	Attachments []timeline.Attachment
+	// Files have Name, Title, Label, LinkLabel, MimeType, Permalink, Thumbnail and IsImage.
+	Files    []timeline.File
+	renderer *TemplateRenderer
+}
+
+func (d TemplateData) ChannelName() string {
+	if d.renderer == nil || d.renderer.channelRepository == nil {
+		return d.ChannelID
+	}
+	c, e := d.renderer.channelRepository.Get(d.ChannelID)
+	if e != nil || c == nil {
+		return d.ChannelID
+	}
+	return c.Name
+}
+
+func (d TemplateData) Permalink() string {
+	if d.renderer == nil {
+		return ""
+	}
+	p, e := d.renderer.permalinks.get(d.renderer.SlackClient, d.ChannelID, d.TimeStamp)
+	if e != nil {
+		d.renderer.logger.Printf("failed to get permalink of %s-%s: %+v\n", d.ChannelID, d.TimeStamp, e)
+		return ""
+	}
+	return p
+}
+
+// Time is the time the message was posted at.
+func (d TemplateData) Time() time.Time {
+	sec := strings.SplitN(d.TimeStamp, ".", 2)[0]
+	n, e := strconv.ParseInt(sec, 10, 64)
+	if e != nil {
+		return time.Time{}
+	}
+	return time.Unix(n, 0)
+}
@@ -0,0 +1,81 @@
+package slack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+type channelsOnMemory map[string]timeline.Channel
+
+func (c channelsOnMemory) Get(channelID string) (*timeline.Channel, error) {
+	ch, found := c[channelID]
+	if !found {
+		return nil, nil
+	}
+	return &ch, nil
+}
+
+func (c channelsOnMemory) GetAll() ([]timeline.Channel, error) {
+	cs := []timeline.Channel{}
+	for _, ch := range c {
+		cs = append(cs, ch)
+	}
+	return cs, nil
+}
+
+// routesForTemplateTest posts the messages from C1 and the others to CT by different routes.
+var routesForTemplateTest = []timeline.Route{
+	{ChannelIDs: []string{"C1"}, Timelines: []string{"CT"}},
+	{Default: true, Timelines: []string{"CT"}},
+}
+
+func newTemplateRendererForTest(d string, ts []string) (TemplateRenderer, error) {
+	channels := channelsOnMemory{"C1": timeline.Channel{ID: "C1", Name: "general"}}
+	router := timeline.NewRouter(routesForTemplateTest, channels)
+	return NewTemplateRenderer(d, ts, router, newSlackClientForTest(), channels, log.New(ioutil.Discard, "", 0))
+}
+
+func TestTemplateRendererUsesTemplateOfRoute(t *testing.T) {
+	r, e := newTemplateRendererForTest(
+		"",
+		[]string{"[#{{.ChannelName}}] {{.UserName}}: {{.Text}}{{if .IsThreadReply}} (in thread){{end}}"},
+	)
+	if !assert.NoError(t, e) {
+		return
+	}
+	u := timeline.User{Name: "dark"}
+	m := timeline.Message{Text: "hello", ChannelID: "C1", TimeStamp: "2.0", ThreadTimeStamp: "1.0"}
+	assert.Equal(t, "hello (at <#C1> )", r.Render(u, m).Text)
+	m.TimelineChannelID = "CT"
+	assert.Equal(t, "[#general] dark: hello (in thread)", r.Render(u, m).Text)
+	// the default route to the same timeline has no template
+	m.ChannelID = "C2"
+	assert.Equal(t, "hello (at <#C2> )", r.Render(u, m).Text)
+}
+
+func TestTemplateRendererWithPermalinkAndTime(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":true,"permalink":"https://example.slack.com/p1"}`)
+	})
+	defer closeServer()
+	r, e := newTemplateRendererForTest(`{{.Text}} {{.Permalink}} {{.Time.UTC.Format "2006-01-02"}}`, nil)
+	if !assert.NoError(t, e) {
+		return
+	}
+	m := timeline.Message{Text: "hello", ChannelID: "C1", TimeStamp: "1500000000.000100"}
+	assert.Equal(t, "hello https://example.slack.com/p1 2017-07-14", r.Render(timeline.User{}, m).Text)
+}
+
+func TestTemplateRendererFailsFastOnBrokenTemplate(t *testing.T) {
+	_, e := newTemplateRendererForTest("{{.Text", nil)
+	assert.Error(t, e)
+	_, e = newTemplateRendererForTest("", []string{"{{.Unknown}}"})
+	assert.Error(t, e)
+}
@@ -0,0 +1,76 @@
+package slack
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ara-ta3/slack-timeline/store"
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+// userKeyPrefix separates users from the mappings in the same backend.
+var userKeyPrefix = "user:"
+
+type cachedUser struct {
+	User     timeline.User `json:"user"`
+	CachedAt time.Time     `json:"cachedAt"`
+}
+
+func (u cachedUser) isExpired(ttl time.Duration, now time.Time) bool {
+	return now.Sub(u.CachedAt) > ttl
+}
+
+// userCacheStore persists users in the backend so that they are not fetched again on start.
+type userCacheStore struct {
+	store store.Backend
+}
+
+func (s userCacheStore) get(userID string) (*cachedUser, error) {
+	data, e := s.store.Get(userKeyPrefix + userID)
+	if e != nil || data == nil {
+		return nil, e
+	}
+	u := cachedUser{}
+	if e := json.Unmarshal(data, &u); e != nil {
+		return nil, e
+	}
+	return &u, nil
+}
+
+func (s userCacheStore) put(u timeline.User, cachedAt time.Time) error {
+	data, e := json.Marshal(cachedUser{User: u, CachedAt: cachedAt})
+	if e != nil {
+		return e
+	}
+	return s.store.Put(userKeyPrefix+u.ID, data)
+}
+
+func (s userCacheStore) all() ([]cachedUser, error) {
+	us := []cachedUser{}
+	e := s.store.Scan(userKeyPrefix, func(k string, v []byte) error {
+		u := cachedUser{}
+		if e := json.Unmarshal(v, &u); e != nil {
+			return nil
+		}
+		us = append(us, u)
+		return nil
+	})
+	return us, e
+}
+
+func (s userCacheStore) clear() error {
+	keys := []string{}
+	e := s.store.Scan(userKeyPrefix, func(k string, v []byte) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if e != nil {
+		return e
+	}
+	for _, k := range keys {
+		if e := s.store.Delete(k); e != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package slack
+
+import "github.com/ara-ta3/slack-timeline/timeline"
+
+func NewUserGroupRepository(s SlackClient) UserGroupRepositoryOnSlack {
+	return UserGroupRepositoryOnSlack{
+		SlackClient: s,
+	}
+}
+
+type UserGroupRepositoryOnSlack struct {
+	SlackClient SlackClient
+}
+
+func (r UserGroupRepositoryOnSlack) GetAll() ([]timeline.UserGroup, error) {
+	gs, err := r.SlackClient.getUserGroups()
+	if err != nil {
+		return nil, err
+	}
+	groups := []timeline.UserGroup{}
+	for _, g := range gs {
+		groups = append(groups, g.ToInternal())
+	}
+	return groups, nil
+}
@@ -1,8 +1,10 @@
 package slack
 
 import (
+	"log"
 	"time"
 
+	"github.com/ara-ta3/slack-timeline/store"
 	"github.com/ara-ta3/slack-timeline/timeline"
 	cache "github.com/patrickmn/go-cache"
 )
@@ -10,51 +12,138 @@ import (
 func NewUserRepository(s SlackClient) UserRepositoryOnSlack {
 	c := cache.New(cache.NoExpiration, 24*time.Hour)
 	return UserRepositoryOnSlack{
-		s,
-		*c,
+		SlackClient: s,
+		cache:       *c,
+		expiration:  cache.NoExpiration,
+	}
+}
+
+// NewPersistentUserRepository returns the repository which also stores users in s.
+// Users older than ttl are fetched again, and on start they are used
+// while all users are refreshed in background.
+func NewPersistentUserRepository(s SlackClient, db store.Backend, ttl time.Duration, logger *log.Logger) UserRepositoryOnSlack {
+	c := cache.New(ttl, 24*time.Hour)
+	return UserRepositoryOnSlack{
+		SlackClient: s,
+		cache:       *c,
+		expiration:  ttl,
+		store:       &userCacheStore{store: db},
+		logger:      logger,
+		now:         time.Now,
 	}
 }
 
 type UserRepositoryOnSlack struct {
 	SlackClient SlackClient
 	cache       cache.Cache
+	expiration  time.Duration
+	store       *userCacheStore
+	logger      *log.Logger
+	now         func() time.Time
 }
 
 func (r UserRepositoryOnSlack) GetAll() ([]timeline.User, error) {
+	if r.store != nil {
+		cached, e := r.store.all()
+		if e != nil {
+			r.logger.Printf("failed to read users from db: %+v\n", e)
+		} else if len(cached) > 0 {
+			return r.useCachedUsers(cached), nil
+		}
+	}
+	return r.fetchAll()
+}
+
+func (r UserRepositoryOnSlack) fetchAll() ([]timeline.User, error) {
 	us, err := r.SlackClient.getAllUsers()
 	if err != nil {
 		return nil, err
 	}
 	users := []timeline.User{}
 	for _, u := range us {
-		r.cache.Set(u.ID, u, cache.NoExpiration)
-		users = append(users, u.ToInternal())
+		user := u.ToInternal()
+		r.set(user)
+		users = append(users, user)
 	}
 
 	return users, nil
 }
 
+// useCachedUsers returns the users stored in db, and refreshes all of them
+// in background if some of them are expired.
+func (r UserRepositoryOnSlack) useCachedUsers(cached []cachedUser) []timeline.User {
+	users := []timeline.User{}
+	expired := false
+	now := r.now()
+	for _, u := range cached {
+		users = append(users, u.User)
+		if u.isExpired(r.expiration, now) {
+			expired = true
+			continue
+		}
+		r.cache.Set(u.User.ID, u.User, r.expiration-now.Sub(u.CachedAt))
+	}
+	if expired {
+		go func() {
+			us, e := r.fetchAll()
+			if e != nil {
+				r.logger.Printf("failed to refresh users: %+v\n", e)
+				return
+			}
+			r.logger.Printf("refreshed %d users\n", len(us))
+		}()
+	}
+	return users
+}
+
 func (r UserRepositoryOnSlack) Get(userID string) (*timeline.User, error) {
 	u, found := r.cache.Get(userID)
-	ret, ok := u.(User)
+	ret, ok := u.(timeline.User)
 	if found && ok {
-		user := ret.ToInternal()
-		return &user, nil
+		return &ret, nil
 	}
 	r.cache.Delete(userID)
 
+	if r.store != nil {
+		c, e := r.store.get(userID)
+		if e != nil {
+			r.logger.Printf("failed to read user %s from db: %+v\n", userID, e)
+		} else if c != nil && !c.isExpired(r.expiration, r.now()) {
+			r.cache.Set(userID, c.User, r.expiration-r.now().Sub(c.CachedAt))
+			return &c.User, nil
+		}
+	}
+
 	uu, err := r.SlackClient.getUser(userID)
 
 	if err != nil {
 		return &timeline.User{}, err
 	}
 
-	r.cache.Set(userID, uu, cache.NoExpiration)
 	user := uu.ToInternal()
+	r.set(user)
 	return &user, nil
 }
 
+func (r UserRepositoryOnSlack) Put(u timeline.User) error {
+	r.set(u)
+	return nil
+}
+
+func (r UserRepositoryOnSlack) set(u timeline.User) {
+	r.cache.Set(u.ID, u, r.expiration)
+	if r.store == nil {
+		return
+	}
+	if e := r.store.put(u, r.now()); e != nil {
+		r.logger.Printf("failed to write user %s to db: %+v\n", u.ID, e)
+	}
+}
+
 func (r UserRepositoryOnSlack) Clear() error {
 	r.cache.Flush()
+	if r.store != nil {
+		return r.store.clear()
+	}
 	return nil
 }
@@ -0,0 +1,88 @@
+package slack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/store"
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+var userCachedAt = time.Unix(1600000000, 0)
+
+func newPersistentUserRepositoryForTest(t *testing.T, now time.Time) (UserRepositoryOnSlack, func()) {
+	db := store.NewMemoryStore()
+	r := NewPersistentUserRepository(newSlackClientForTest(), db, time.Hour, log.New(ioutil.Discard, "", 0))
+	r.now = func() time.Time { return now }
+	r.store.put(timeline.User{ID: "U1", Name: "cached"}, userCachedAt)
+	return r, func() { db.Close() }
+}
+
+func TestPersistentUserRepositoryUsesStoredUsers(t *testing.T) {
+	var calls int32
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, `{"ok":false,"error":"should_not_be_called"}`)
+	})
+	defer closeServer()
+	r, closeDB := newPersistentUserRepositoryForTest(t, userCachedAt.Add(time.Minute))
+	defer closeDB()
+
+	us, e := r.GetAll()
+	if assert.NoError(t, e) {
+		assert.Equal(t, []timeline.User{{ID: "U1", Name: "cached"}}, us)
+	}
+	u, e := r.Get("U1")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "cached", u.Name)
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestPersistentUserRepositoryRefreshesExpiredUsersInBackground(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users.list":
+			fmt.Fprint(w, `{"ok":true,"members":[{"id":"U1","name":"refreshed"},{"id":"U2","name":"newcomer"}]}`)
+		case "/users.info":
+			fmt.Fprint(w, `{"ok":true,"user":{"id":"U1","name":"fetched"}}`)
+		}
+	})
+	defer closeServer()
+	r, closeDB := newPersistentUserRepositoryForTest(t, userCachedAt.Add(2*time.Hour))
+	defer closeDB()
+
+	us, e := r.GetAll()
+	if assert.NoError(t, e) {
+		assert.Equal(t, []timeline.User{{ID: "U1", Name: "cached"}}, us)
+	}
+	assert.Eventually(t, func() bool {
+		c, _ := r.store.get("U2")
+		return c != nil
+	}, time.Second, 10*time.Millisecond)
+	c, _ := r.store.get("U1")
+	assert.Equal(t, "refreshed", c.User.Name)
+}
+
+func TestPersistentUserRepositoryFetchesExpiredUser(t *testing.T) {
+	closeServer := newSlackAPIStandIn(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":true,"user":{"id":"U1","name":"fetched"}}`)
+	})
+	defer closeServer()
+	r, closeDB := newPersistentUserRepositoryForTest(t, userCachedAt.Add(2*time.Hour))
+	defer closeDB()
+
+	u, e := r.Get("U1")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "fetched", u.Name)
+	}
+	c, _ := r.store.get("U1")
+	assert.Equal(t, "fetched", c.User.Name)
+}
@@ -2,6 +2,8 @@ package slack
 
 import (
 	"encoding/json"
+	"log"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/net/websocket"
@@ -10,7 +12,11 @@ import (
 )
 
 type SlackTimelineWorker struct {
-	rtmClient RTMClient
+	rtmClient  RTMClient
+	backfiller Backfiller
+	reconnect  ReconnectPolicy
+	logger     *log.Logger
+	sleep      func(time.Duration)
 }
 
 type RTMClient interface {
@@ -39,31 +45,57 @@ func (c SlackRTMConnection) Close() error {
 	return c.ws.Close()
 }
 
-func NewSlackTimelineWorker(rtmClient RTMClient) SlackTimelineWorker {
+type rtmEvent struct {
+	Type string `json:"type"`
+}
+
+func NewSlackTimelineWorker(rtmClient RTMClient, backfiller Backfiller, reconnect ReconnectPolicy, logger *log.Logger) SlackTimelineWorker {
 	return SlackTimelineWorker{
-		rtmClient: rtmClient,
+		rtmClient:  rtmClient,
+		backfiller: backfiller,
+		reconnect:  reconnect,
+		logger:     logger,
+		sleep:      time.Sleep,
 	}
 }
 
-func (w SlackTimelineWorker) Polling(
-	messageChan, deletedMessageChan chan *timeline.Message,
-	errorChan chan error,
-	endChan chan bool,
-	userCacheClearChan chan interface{},
-) {
-	con, e := w.rtmClient.ConnectToRTM()
-	if e != nil {
-		err := errors.Wrap(e, "failed to connecting to slack rtm")
-		errorChan <- err
+func (w SlackTimelineWorker) Polling(events timeline.Events) {
+	events.Error <- w.reconnect.keepConnected("slack rtm", w.logger, w.sleep, func() (int, error) {
+		con, e := w.rtmClient.ConnectToRTM()
+		if e != nil {
+			return 0, errors.Wrap(e, "failed to connecting to slack rtm")
+		}
+		defer con.Close()
+		backfill(w.backfiller, events.Message, w.logger)
+		n, e := w.receive(con, events)
+		if e != nil {
+			return n, errors.Wrap(e, "failed to reading from slack rtm")
+		}
+		return n, nil
+	})
+}
+
+// backfill catches up on the messages posted while disconnected.
+// It is called after connecting so that nothing is missed between the two.
+func backfill(b Backfiller, messageChan chan *timeline.Message, logger *log.Logger) {
+	if b == nil {
 		return
 	}
-	defer con.Close()
+	if e := b.Backfill(messageChan); e != nil {
+		logger.Printf("failed to backfill messages: %+v\n", e)
+	}
+}
+
+// receive reads events from con until the connection fails or Slack sends goodbye.
+// It returns the number of events received and the error which closed the connection,
+// which is nil in case of goodbye. Frames like hello which every connection gets are not counted.
+func (w SlackTimelineWorker) receive(con RTMConnection, events timeline.Events) (int, error) {
+	n := 0
 	prev := make([]byte, 0)
 	for {
 		received, e := con.Read()
 		if e != nil {
-			err := errors.Wrap(e, "failed to reading from slack rtm")
-			errorChan <- err
+			return n, e
 		}
 		msg := append(prev, received...)
 		if !isValidJson(msg) {
@@ -71,33 +103,89 @@ func (w SlackTimelineWorker) Polling(
 			continue
 		}
 		prev = make([]byte, 0)
-		message := SlackMessage{}
-		errOnMessage := json.Unmarshal(msg, &message)
-		if errOnMessage != nil {
+		event := rtmEvent{}
+		if e := json.Unmarshal(msg, &event); e != nil {
 			continue
 		}
-
-		message.Raw = string(msg)
-		if message.Type != "message" {
+		switch event.Type {
+		case "goodbye":
+			return n, nil
+		case "hello", "pong", "reconnect_url":
 			continue
 		}
+		n++
+		dispatch(events, msg)
+	}
+}
 
-		if message.IsMessageToPost() {
-			m := message.ToInternal()
-			messageChan <- &m
+// dispatch decodes an event and sends it to the channel of events for its type and subtype.
+// Events other than message, user_change, team_join and reactions to messages are ignored.
+func dispatch(events timeline.Events, msg []byte) {
+	event := rtmEvent{}
+	if e := json.Unmarshal(msg, &event); e != nil {
+		return
+	}
+	if event.Type == "user_change" || event.Type == "team_join" {
+		u := userEvent{}
+		if e := json.Unmarshal(msg, &u); e != nil || u.User.ID == "" {
+			return
 		}
+		user := u.User.ToInternal()
+		events.UserChanged <- &user
+		return
+	}
+	if event.Type == "reaction_added" || event.Type == "reaction_removed" {
+		r := reactionEvent{}
+		if e := json.Unmarshal(msg, &r); e != nil || r.Item.Type != "message" {
+			return
+		}
+		reaction := r.ToInternal()
+		if event.Type == "reaction_added" {
+			events.ReactionAdded <- &reaction
+		} else {
+			events.ReactionRemoved <- &reaction
+		}
+		return
+	}
 
-		if message.IsDeletedMessage() {
-			d := deletedEvent{}
-			e := json.Unmarshal([]byte(msg), &d)
-			if e != nil {
-				continue
-			}
-			d.Message.ChannelID = d.ChannelID
-			m := d.Message.ToInternal()
-			deletedMessageChan <- &m
-		} else if message.Text == "timeline clear" {
-			userCacheClearChan <- true
+	message := SlackMessage{}
+	errOnMessage := json.Unmarshal(msg, &message)
+	if errOnMessage != nil {
+		return
+	}
+	if message.Type != "message" {
+		return
+	}
+	message.Raw = string(msg)
+
+	if message.IsMessageToPost() {
+		m := message.ToInternal()
+		events.Message <- &m
+	}
+
+	if message.IsDeletedMessage() {
+		d := deletedEvent{}
+		e := json.Unmarshal(msg, &d)
+		if e != nil {
+			return
+		}
+		d.Message.ChannelID = d.ChannelID
+		m := d.Message.ToInternal()
+		events.Deleted <- &m
+	} else if message.IsChangedMessage() {
+		ch := changedEvent{}
+		e := json.Unmarshal(msg, &ch)
+		if e != nil {
+			return
+		}
+		if ch.Message.Text == ch.PreviousMessage.Text {
+			// link unfurls and thread updates do not change the text
+			return
 		}
+		ch.Message.ChannelID = ch.ChannelID
+		m := ch.Message.ToInternal()
+		events.Updated <- &m
+	} else if message.Text == "timeline clear" {
+		events.UserCacheClear <- true
 	}
 }
@@ -0,0 +1,234 @@
+package slack
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+type fakeRTMConnection struct {
+	frames []string
+	closed bool
+}
+
+func (c *fakeRTMConnection) Read() ([]byte, error) {
+	if len(c.frames) == 0 {
+		return nil, errors.New("connection reset")
+	}
+	f := c.frames[0]
+	c.frames = c.frames[1:]
+	return []byte(f), nil
+}
+
+func (c *fakeRTMConnection) Close() error {
+	c.closed = true
+	return nil
+}
+
+type fakeRTMClient struct {
+	connections []*fakeRTMConnection
+	dialed      int
+}
+
+func (c *fakeRTMClient) ConnectToRTM() (RTMConnection, error) {
+	c.dialed++
+	if len(c.connections) == 0 {
+		return nil, errors.New("dial failed")
+	}
+	con := c.connections[0]
+	c.connections = c.connections[1:]
+	return con, nil
+}
+
+type pollingResult struct {
+	messages  []*timeline.Message
+	updated   []*timeline.Message
+	users     []*timeline.User
+	reactions []*timeline.Reaction
+	err       error
+	waits     []time.Duration
+}
+
+func pollForTest(client RTMClient, maxRetries int) pollingResult {
+	result := pollingResult{}
+	w := NewSlackTimelineWorker(
+		client,
+		nil,
+		NewReconnectPolicy(maxRetries, time.Second, 4*time.Second),
+		log.New(ioutil.Discard, "", 0),
+	)
+	w.sleep = func(d time.Duration) {
+		result.waits = append(result.waits, d)
+	}
+
+	events := timeline.NewEvents()
+	go w.Polling(events)
+	for {
+		select {
+		case m := <-events.Message:
+			result.messages = append(result.messages, m)
+		case <-events.Deleted:
+		case m := <-events.Updated:
+			result.updated = append(result.updated, m)
+		case u := <-events.UserChanged:
+			result.users = append(result.users, u)
+		case r := <-events.ReactionAdded:
+			result.reactions = append(result.reactions, r)
+		case r := <-events.ReactionRemoved:
+			result.reactions = append(result.reactions, r)
+		case e := <-events.Error:
+			result.err = e
+			return result
+		}
+	}
+}
+
+func TestPollingReconnectsAfterReadError(t *testing.T) {
+	first := &fakeRTMConnection{frames: []string{
+		`{"type":"message","user":"U1","text":"hello","channel":"C1","ts":"1.0"}`,
+	}}
+	second := &fakeRTMConnection{frames: []string{
+		`{"type":"message","user":"U1","text":"world","channel":"C1","ts":"2.0"}`,
+	}}
+	client := &fakeRTMClient{connections: []*fakeRTMConnection{first, second}}
+
+	r := pollForTest(client, 2)
+
+	assert.Error(t, r.err)
+	if assert.Len(t, r.messages, 2) {
+		assert.Equal(t, "hello", r.messages[0].Text)
+		assert.Equal(t, "world", r.messages[1].Text)
+	}
+	assert.True(t, first.closed)
+	assert.True(t, second.closed)
+	// two connections and two failed dials after them
+	assert.Equal(t, 4, client.dialed)
+}
+
+func TestPollingGivesUpAfterRetryBudget(t *testing.T) {
+	client := &fakeRTMClient{}
+
+	r := pollForTest(client, 3)
+
+	assert.Error(t, r.err)
+	assert.Equal(t, 4, client.dialed)
+	assert.Len(t, r.waits, 3)
+}
+
+func TestPollingBacksOffExponentially(t *testing.T) {
+	client := &fakeRTMClient{}
+
+	r := pollForTest(client, 4)
+
+	bounds := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second}
+	if assert.Len(t, r.waits, len(bounds)) {
+		for i, b := range bounds {
+			assert.True(t, r.waits[i] >= b/2 && r.waits[i] <= b, "wait %d was %v", i, r.waits[i])
+		}
+	}
+}
+
+func TestPollingReconnectsImmediatelyOnGoodbye(t *testing.T) {
+	first := &fakeRTMConnection{frames: []string{`{"type":"goodbye"}`}}
+	second := &fakeRTMConnection{frames: []string{
+		`{"type":"message","user":"U1","text":"after goodbye","channel":"C1","ts":"1.0"}`,
+	}}
+	client := &fakeRTMClient{connections: []*fakeRTMConnection{first, second}}
+
+	r := pollForTest(client, 1)
+
+	if assert.Len(t, r.messages, 1) {
+		assert.Equal(t, "after goodbye", r.messages[0].Text)
+	}
+	// goodbye does not wait, only the failures after second connection do
+	assert.Len(t, r.waits, 1)
+}
+
+func TestPollingSendsChangedMessage(t *testing.T) {
+	con := &fakeRTMConnection{frames: []string{
+		`{"type":"message","subtype":"message_changed","channel":"C1","message":{"type":"message","user":"U1","text":"edited","ts":"1.0"},"previous_message":{"type":"message","user":"U1","text":"original","ts":"1.0"}}`,
+		`{"type":"message","subtype":"message_changed","channel":"C1","message":{"type":"message","user":"U1","text":"same","ts":"2.0"},"previous_message":{"type":"message","user":"U1","text":"same","ts":"2.0"}}`,
+	}}
+	client := &fakeRTMClient{connections: []*fakeRTMConnection{con}}
+
+	r := pollForTest(client, 1)
+
+	if assert.Len(t, r.updated, 1) {
+		assert.Equal(t, "edited", r.updated[0].Text)
+		assert.Equal(t, "C1", r.updated[0].ChannelID)
+		assert.Equal(t, "1.0", r.updated[0].TimeStamp)
+	}
+}
+
+func TestPollingSendsChangedUser(t *testing.T) {
+	con := &fakeRTMConnection{frames: []string{
+		`{"type":"user_change","user":{"id":"U1","name":"renamed","profile":{"display_name":"Renamed","real_name":"Re Named","image_48":"https://example.com/1.png","image_72":"https://example.com/1_72.png"}}}`,
+		`{"type":"team_join","user":{"id":"U2","name":"newcomer"}}`,
+	}}
+	client := &fakeRTMClient{connections: []*fakeRTMConnection{con}}
+
+	r := pollForTest(client, 1)
+
+	if assert.Len(t, r.users, 2) {
+		assert.Equal(t, timeline.User{
+			ID:               "U1",
+			Name:             "renamed",
+			DisplayName:      "Renamed",
+			RealName:         "Re Named",
+			ProfileImageURL:  "https://example.com/1.png",
+			ProfileImageURLs: timeline.ProfileImageURLs{Size72: "https://example.com/1_72.png"},
+		}, *r.users[0])
+		assert.Equal(t, "newcomer", r.users[1].Name)
+	}
+}
+
+func TestPollingSendsReactionsToMessages(t *testing.T) {
+	con := &fakeRTMConnection{frames: []string{
+		`{"type":"reaction_added","user":"U1","reaction":"tada","item":{"type":"message","channel":"C1","ts":"1.0"}}`,
+		`{"type":"reaction_added","user":"U1","reaction":"tada","item":{"type":"file","file":"F1"}}`,
+		`{"type":"reaction_removed","user":"U2","reaction":"+1","item":{"type":"message","channel":"C1","ts":"2.0"}}`,
+	}}
+	client := &fakeRTMClient{connections: []*fakeRTMConnection{con}}
+
+	r := pollForTest(client, 1)
+
+	if assert.Len(t, r.reactions, 2) {
+		assert.Equal(t, timeline.NewReaction("tada", "U1", "C1", "1.0"), *r.reactions[0])
+		assert.Equal(t, timeline.NewReaction("+1", "U2", "C1", "2.0"), *r.reactions[1])
+	}
+}
+
+func TestDispatchParsesChangedMessage(t *testing.T) {
+	updated := make(chan *timeline.Message, 1)
+	dispatch(timeline.Events{Updated: updated}, []byte(`{"type":"message","subtype":"message_changed","hidden":true,"channel":"C1","ts":"3.0","message":{"type":"message","user":"U1","text":"edited <@U2|bob>","ts":"2.0","thread_ts":"1.0","edited":{"user":"U1","ts":"3.0"}},"previous_message":{"type":"message","user":"U1","text":"original","ts":"2.0","thread_ts":"1.0"}}`))
+
+	select {
+	case m := <-updated:
+		assert.Equal(t, "edited bob", m.Text)
+		assert.Equal(t, "U1", m.UserID)
+		assert.Equal(t, "C1", m.ChannelID)
+		assert.Equal(t, "2.0", m.TimeStamp)
+		assert.Equal(t, "1.0", m.ThreadTimeStamp)
+	default:
+		t.Fatal("changed message was not dispatched")
+	}
+}
+
+func TestPollingGivesUpWhenConnectionsDropAfterHello(t *testing.T) {
+	connections := []*fakeRTMConnection{}
+	for i := 0; i < 5; i++ {
+		connections = append(connections, &fakeRTMConnection{frames: []string{`{"type":"hello"}`}})
+	}
+	client := &fakeRTMClient{connections: connections}
+
+	r := pollForTest(client, 2)
+
+	assert.Error(t, r.err)
+	assert.Equal(t, 3, client.dialed)
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MappingFilter selects mappings. Empty fields select all.
+// ChannelID and TimeStamp match either the source message or the post in the timeline.
+// Since and Until match the time the message was posted to the timeline, and Until is exclusive.
+type MappingFilter struct {
+	ChannelID string
+	TimeStamp string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f MappingFilter) match(m Mapping) bool {
+	if f.ChannelID != "" || f.TimeStamp != "" {
+		source := (f.ChannelID == "" || m.SourceChannelID == f.ChannelID) &&
+			(f.TimeStamp == "" || m.SourceTimeStamp == f.TimeStamp)
+		posted := (f.ChannelID == "" || m.TimelineChannelID == f.ChannelID) &&
+			(f.TimeStamp == "" || m.TimelineTimeStamp == f.TimeStamp)
+		if !source && !posted {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && m.PostedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !m.PostedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Mappings returns the mappings selected by f in the order of keys.
+func Mappings(s MappingReader, f MappingFilter) ([]Mapping, error) {
+	ms := []Mapping{}
+	e := s.Each(func(m Mapping) error {
+		if f.match(m) {
+			ms = append(ms, m)
+		}
+		return nil
+	})
+	return ms, e
+}
+
+// Remove removes the mappings selected by f and returns the number of them.
+// The posts in the timeline are kept.
+func Remove(s MappingStore, f MappingFilter) (int, error) {
+	ms, e := Mappings(s, f)
+	if e != nil {
+		return 0, e
+	}
+	for i, m := range ms {
+		if e := s.Delete(m); e != nil {
+			return i, e
+		}
+	}
+	return len(ms), nil
+}
+
+// Export writes all mappings to w as JSON Lines and returns the number of them.
+func Export(s MappingReader, w io.Writer) (int, error) {
+	ms, e := Mappings(s, MappingFilter{})
+	if e != nil {
+		return 0, e
+	}
+	encoder := json.NewEncoder(w)
+	for i, m := range ms {
+		if e := encoder.Encode(m); e != nil {
+			return i, errors.Wrap(e, "failed to export mapping of "+m.Key())
+		}
+	}
+	return len(ms), nil
+}
+
+// Import reads mappings in JSON Lines from r and writes them in the current version.
+// It returns the number of imported mappings, and stops at the first invalid line.
+func Import(s MappingStore, r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		m := Mapping{}
+		if e := json.Unmarshal(scanner.Bytes(), &m); e != nil {
+			return n, errors.Wrap(e, fmt.Sprintf("failed to decode line %d", line))
+		}
+		if m.SourceChannelID == "" || m.SourceTimeStamp == "" || m.TimelineChannelID == "" || m.TimelineTimeStamp == "" {
+			return n, errors.New(fmt.Sprintf("line %d does not have channels and timestamps", line))
+		}
+		m.Version = MappingVersion
+		if e := s.Put(m); e != nil {
+			return n, e
+		}
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// Stats is the summary of the mappings in the store.
+type Stats struct {
+	Mappings int
+	// Timelines is the number of mappings for each timeline channel.
+	Timelines map[string]int
+	// Versions is the number of mappings for each version.
+	Versions map[int]int
+	Oldest   time.Time
+	Newest   time.Time
+}
+
+func CollectStats(s MappingReader) (Stats, error) {
+	stats := Stats{
+		Timelines: map[string]int{},
+		Versions:  map[int]int{},
+	}
+	e := s.Each(func(m Mapping) error {
+		stats.Mappings++
+		stats.Timelines[m.TimelineChannelID]++
+		stats.Versions[m.Version]++
+		if m.PostedAt.IsZero() {
+			return nil
+		}
+		if stats.Oldest.IsZero() || m.PostedAt.Before(stats.Oldest) {
+			stats.Oldest = m.PostedAt
+		}
+		if m.PostedAt.After(stats.Newest) {
+			stats.Newest = m.PostedAt
+		}
+		return nil
+	})
+	return stats, e
+}
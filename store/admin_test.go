@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStoreWithMappingsForTest() MappingStore {
+	b := NewMemoryStore()
+	s := NewMappingStore(b)
+	for _, m := range []Mapping{
+		{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CT", TimelineTimeStamp: "9.1", PostedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CA", TimelineTimeStamp: "9.2", PostedAt: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{Version: MappingVersion, SourceChannelID: "C2", SourceTimeStamp: "2.0", TimelineChannelID: "CT", TimelineTimeStamp: "9.3", PostedAt: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)},
+	} {
+		s.Put(m)
+	}
+	b.Put("user:U1", []byte(`{"user":{"ID":"U1"},"cachedAt":"2020-01-01T00:00:00Z"}`))
+	return s
+}
+
+func keysOf(ms []Mapping) []string {
+	ks := []string{}
+	for _, m := range ms {
+		ks = append(ks, m.Key())
+	}
+	return ks
+}
+
+func TestMappingsWithFilter(t *testing.T) {
+	s := newStoreWithMappingsForTest()
+	for _, c := range []struct {
+		filter   MappingFilter
+		expected []string
+	}{
+		{MappingFilter{}, []string{"C1-1.0-CA", "C1-1.0-CT", "C2-2.0-CT"}},
+		{MappingFilter{ChannelID: "C1"}, []string{"C1-1.0-CA", "C1-1.0-CT"}},
+		{MappingFilter{ChannelID: "CT"}, []string{"C1-1.0-CT", "C2-2.0-CT"}},
+		{MappingFilter{ChannelID: "CT", TimeStamp: "9.3"}, []string{"C2-2.0-CT"}},
+		{MappingFilter{Since: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)}, []string{"C1-1.0-CA", "C2-2.0-CT"}},
+		{MappingFilter{Until: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)}, []string{"C1-1.0-CT"}},
+	} {
+		ms, e := Mappings(s, c.filter)
+		if assert.NoError(t, e) {
+			assert.Equal(t, c.expected, keysOf(ms), "%+v", c.filter)
+		}
+	}
+}
+
+func TestExportAndImport(t *testing.T) {
+	s := newStoreWithMappingsForTest()
+	b := &bytes.Buffer{}
+	n, e := Export(s, b)
+	if !assert.NoError(t, e) || !assert.Equal(t, 3, n) {
+		return
+	}
+
+	imported := NewMappingStore(NewMemoryStore())
+	n, e = Import(imported, b)
+	if assert.NoError(t, e) {
+		assert.Equal(t, 3, n)
+	}
+	ms, _ := Mappings(imported, MappingFilter{})
+	assert.Equal(t, []string{"C1-1.0-CA", "C1-1.0-CT", "C2-2.0-CT"}, keysOf(ms))
+
+	_, e = Import(imported, strings.NewReader(`{"sourceChannelID":"C3"}`))
+	assert.Error(t, e)
+}
+
+func TestRemoveAndStats(t *testing.T) {
+	s := newStoreWithMappingsForTest()
+	n, e := Remove(s, MappingFilter{ChannelID: "CA", TimeStamp: "9.2"})
+	if assert.NoError(t, e) {
+		assert.Equal(t, 1, n)
+	}
+
+	stats, e := CollectStats(s)
+	if assert.NoError(t, e) {
+		assert.Equal(t, 2, stats.Mappings)
+		assert.Equal(t, map[string]int{"CT": 2}, stats.Timelines)
+		assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), stats.Oldest)
+		assert.Equal(t, time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC), stats.Newest)
+	}
+}
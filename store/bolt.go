@@ -0,0 +1,96 @@
+package store
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("mappings")
+
+// BoltStore is the backend in a bucket of BoltDB.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the file, which fails if another process has opened it.
+func OpenBoltStore(path string) (BoltStore, error) {
+	db, e := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if e == bolt.ErrTimeout {
+		return BoltStore{}, ErrLocked
+	}
+	if e != nil {
+		return BoltStore{}, errors.Wrap(e, "failed to open bolt db "+path)
+	}
+	e = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists(boltBucket)
+		return e
+	})
+	if e != nil {
+		db.Close()
+		return BoltStore{}, errors.Wrap(e, "failed to create bucket in "+path)
+	}
+	return BoltStore{db: db}, nil
+}
+
+func (s BoltStore) Get(key string) ([]byte, error) {
+	var v []byte
+	e := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltBucket).Get([]byte(key)); b != nil {
+			v = append([]byte{}, b...)
+		}
+		return nil
+	})
+	return v, e
+}
+
+func (s BoltStore) Put(key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), value)
+	})
+}
+
+func (s BoltStore) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+func (s BoltStore) Scan(prefix string, f func(key string, value []byte) error) error {
+	p := []byte(prefix)
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if e := f(string(k), append([]byte{}, v...)); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+}
+
+func (s BoltStore) LastKey(prefix string) (string, error) {
+	last := ""
+	e := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		var k []byte
+		if end := prefixEnd(prefix); end == nil {
+			k, _ = c.Last()
+		} else if k, _ = c.Seek(end); k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+		if k != nil && bytes.HasPrefix(k, []byte(prefix)) {
+			last = string(k)
+		}
+		return nil
+	})
+	return last, e
+}
+
+func (s BoltStore) Close() error {
+	return s.db.Close()
+}
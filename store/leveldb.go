@@ -0,0 +1,82 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDBStore is the backend in LevelDB, which is the default backend.
+type LevelDBStore struct {
+	db *leveldb.DB
+}
+
+func OpenLevelDBStore(path string) (LevelDBStore, error) {
+	return openLevelDBStore(path, nil)
+}
+
+// OpenExistingLevelDBStore opens the db, which fails if it does not exist instead of creating it.
+func OpenExistingLevelDBStore(path string) (LevelDBStore, error) {
+	return openLevelDBStore(path, &opt.Options{ErrorIfMissing: true})
+}
+
+func openLevelDBStore(path string, o *opt.Options) (LevelDBStore, error) {
+	db, e := leveldb.OpenFile(path, o)
+	if isLocked(e) {
+		return LevelDBStore{}, ErrLocked
+	}
+	if e != nil {
+		return LevelDBStore{}, errors.Wrap(e, "failed to open leveldb "+path)
+	}
+	return NewLevelDBStore(db), nil
+}
+
+func NewLevelDBStore(db *leveldb.DB) LevelDBStore {
+	return LevelDBStore{db: db}
+}
+
+func (s LevelDBStore) Get(key string) ([]byte, error) {
+	v, e := s.db.Get([]byte(key), nil)
+	if e == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return v, e
+}
+
+func (s LevelDBStore) Put(key string, value []byte) error {
+	return s.db.Put([]byte(key), value, nil)
+}
+
+func (s LevelDBStore) Delete(key string) error {
+	return s.db.Delete([]byte(key), nil)
+}
+
+func (s LevelDBStore) Scan(prefix string, f func(key string, value []byte) error) error {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if e := f(string(iter.Key()), append([]byte{}, iter.Value()...)); e != nil {
+			return e
+		}
+	}
+	return iter.Error()
+}
+
+func (s LevelDBStore) LastKey(prefix string) (string, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	if !iter.Last() {
+		return "", iter.Error()
+	}
+	return string(iter.Key()), iter.Error()
+}
+
+// Compact compacts the whole db to reclaim the space of deleted records.
+func (s LevelDBStore) Compact() error {
+	return s.db.CompactRange(util.Range{})
+}
+
+func (s LevelDBStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,28 @@
+package store
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned when the db has been opened by another process,
+// such as SlackTimeline running with the db.
+var ErrLocked = errors.New("db is locked by another process")
+
+// lockFile creates the file and locks it exclusively until it is closed.
+// It fails with ErrLocked if another one has locked it.
+func lockFile(path string) (*os.File, error) {
+	f, e := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if e != nil {
+		return nil, errors.Wrap(e, "failed to open lock file "+path)
+	}
+	if e := lockExclusive(f); e != nil {
+		f.Close()
+		if isLocked(e) {
+			return nil, ErrLocked
+		}
+		return nil, errors.Wrap(e, "failed to lock "+path)
+	}
+	return f, nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockExclusive locks the file without waiting for the lock of another process.
+func lockExclusive(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// isLocked tells whether the error is because another process has locked the file,
+// which is also what LevelDB returns for its LOCK file.
+func isLocked(e error) bool {
+	return e == syscall.EWOULDBLOCK
+}
@@ -0,0 +1,24 @@
+//go:build windows
+
+package store
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockExclusive locks the file without waiting for the lock of another process.
+func lockExclusive(f *os.File) error {
+	return windows.LockFileEx(
+		windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{},
+	)
+}
+
+// isLocked tells whether the error is because another process has locked the file.
+// LevelDB opens its LOCK file without sharing it, which fails with ERROR_SHARING_VIOLATION.
+func isLocked(e error) bool {
+	return e == windows.ERROR_LOCK_VIOLATION || e == windows.ERROR_SHARING_VIOLATION
+}
@@ -0,0 +1,207 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+// MappingVersion is the version of the format of Mapping which is written to the store.
+// Raw responses of chat.postMessage written by older versions are version 0.
+const MappingVersion = 1
+
+// Mapping records the post in the timeline which a source message was mirrored to.
+type Mapping struct {
+	Version           int       `json:"version"`
+	SourceChannelID   string    `json:"sourceChannelID"`
+	SourceTimeStamp   string    `json:"sourceTs"`
+	TimelineChannelID string    `json:"timelineChannelID"`
+	TimelineTimeStamp string    `json:"timelineTs"`
+	UserID            string    `json:"userID"`
+	PostedAt          time.Time `json:"postedAt"`
+	// TextHash is the hash of the text rendered for the post, which is empty for version 0.
+	TextHash string `json:"textHash"`
+}
+
+// MappingKey returns the key of the mapping, which starts with the channel and the timestamp
+// of the source message so that the mappings of a channel are ordered by time.
+func MappingKey(sourceChannelID, sourceTimeStamp, timelineChannelID string) string {
+	return sourceChannelID + "-" + sourceTimeStamp + "-" + timelineChannelID
+}
+
+// HashText returns TextHash of the rendered texts.
+func HashText(texts ...string) string {
+	h := sha256.New()
+	for _, t := range texts {
+		h.Write([]byte(t))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (m Mapping) Key() string {
+	return MappingKey(m.SourceChannelID, m.SourceTimeStamp, m.TimelineChannelID)
+}
+
+func (m Mapping) Encode() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+// legacyMapping is the raw response of chat.postMessage.
+type legacyMapping struct {
+	Channel   string `json:"channel"`
+	TimeStamp string `json:"ts"`
+	Message   struct {
+		UserID string `json:"user"`
+	} `json:"message"`
+}
+
+// DecodeError is returned when the record of the key is not a mapping.
+// It is skippable since only the message of the mapping cannot be handled.
+type DecodeError struct {
+	Key string
+	Err error
+}
+
+func (e DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode mapping of %s: %s", e.Key, e.Err.Error())
+}
+
+func (e DecodeError) Cause() error {
+	return e.Err
+}
+
+func (e DecodeError) Kind() timeline.ErrorKind {
+	return timeline.ErrorSkippable
+}
+
+// DecodeMapping decodes the mapping stored with the key.
+// Raw responses of chat.postMessage are decoded as version 0.
+func DecodeMapping(key string, data []byte) (Mapping, error) {
+	m := Mapping{}
+	if e := json.Unmarshal(data, &m); e != nil {
+		return Mapping{}, DecodeError{Key: key, Err: e}
+	}
+	if m.Version > 0 {
+		return m, nil
+	}
+	l := legacyMapping{}
+	if e := json.Unmarshal(data, &l); e != nil {
+		return Mapping{}, DecodeError{Key: key, Err: e}
+	}
+	if l.Channel == "" || l.TimeStamp == "" {
+		return Mapping{}, DecodeError{Key: key, Err: errors.New("not a mapping")}
+	}
+	// keys of version 0 may not have the timeline channel
+	ks := strings.SplitN(key, "-", 3)
+	if len(ks) < 2 {
+		return Mapping{}, DecodeError{Key: key, Err: errors.New("invalid key")}
+	}
+	return Mapping{
+		SourceChannelID:   ks[0],
+		SourceTimeStamp:   ks[1],
+		TimelineChannelID: l.Channel,
+		TimelineTimeStamp: l.TimeStamp,
+		UserID:            l.Message.UserID,
+		PostedAt:          timeOf(l.TimeStamp),
+	}, nil
+}
+
+// timeOf returns the time of the timestamp of Slack.
+func timeOf(ts string) time.Time {
+	sec, e := strconv.ParseInt(strings.SplitN(ts, ".", 2)[0], 10, 64)
+	if e != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// eachLegacy calls f with the keys of the records written by older versions without the prefix of keys
+// and their mappings, or the errors of decoding them if they are not mappings.
+func eachLegacy(b Backend, f func(k string, m Mapping, e error) error) error {
+	return b.Scan("", func(k string, v []byte) error {
+		if strings.Contains(k, ":") {
+			return nil
+		}
+		m, e := DecodeMapping(k, v)
+		return f(k, m, e)
+	})
+}
+
+// MappingReader reads mappings without changing the store.
+type MappingReader interface {
+	// Each calls f with every mapping. It stops at the first error from f and returns it.
+	Each(f func(m Mapping) error) error
+}
+
+// NewMappingReader returns the reader of the mappings in the mapping store and the ones
+// written by older versions which have not been migrated, which are read as version 0.
+// The mappings of older versions follow the others.
+func NewMappingReader(b Backend) MappingReader {
+	return mappingReader{backend: b}
+}
+
+type mappingReader struct {
+	backend Backend
+}
+
+func (r mappingReader) Each(f func(m Mapping) error) error {
+	if e := NewMappingStore(r.backend).Each(f); e != nil {
+		return e
+	}
+	return eachLegacy(r.backend, func(k string, m Mapping, e error) error {
+		if e != nil {
+			return nil
+		}
+		return f(m)
+	})
+}
+
+// Migrate moves the mappings written by older versions without the prefix of keys into the mapping store
+// in the current version, and returns the number of moved mappings.
+// Records of older versions which are not mappings, like the responses of failed posts, are deleted.
+// Records with prefixes of keys are kept as they are.
+func Migrate(b Backend) (int, error) {
+	olds := map[string]Mapping{}
+	invalids := []string{}
+	e := eachLegacy(b, func(k string, m Mapping, e error) error {
+		if e != nil {
+			invalids = append(invalids, k)
+			return nil
+		}
+		olds[k] = m
+		return nil
+	})
+	if e != nil {
+		return 0, errors.Wrap(e, "failed to scan mappings to migrate")
+	}
+	for _, k := range invalids {
+		if e := b.Delete(k); e != nil {
+			return 0, errors.Wrap(e, "failed to delete invalid mapping of "+k)
+		}
+	}
+	s := NewMappingStore(b)
+	for k, m := range olds {
+		m.Version = MappingVersion
+		// the mapping of the same timeline channel with the current key is newer
+		if existing, e := s.Get(m.SourceChannelID, m.SourceTimeStamp, m.TimelineChannelID); e != nil {
+			return 0, e
+		} else if existing == nil || m.Key() == k {
+			if e := s.Put(m); e != nil {
+				return 0, errors.Wrap(e, "failed to write migrated mapping of "+k)
+			}
+		}
+		if e := b.Delete(k); e != nil {
+			return 0, errors.Wrap(e, "failed to delete mapping of "+k)
+		}
+	}
+	return len(olds), nil
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// mappingKeyPrefix separates mappings from the other records in the backend.
+var mappingKeyPrefix = "mapping:"
+
+// latestKeyPrefix is the prefix of the keys of the newest timestamps of source channels,
+// which are kept when their mappings are deleted.
+var latestKeyPrefix = "latest:"
+
+// MappingStore records and looks up the posts in the timeline which source messages were mirrored to.
+type MappingStore interface {
+	// Get returns the mapping of the source message to the timeline channel, or nil if it is not found.
+	Get(sourceChannelID, sourceTimeStamp, timelineChannelID string) (*Mapping, error)
+	// Find returns the mappings of the source message to every timeline channel.
+	Find(sourceChannelID, sourceTimeStamp string) ([]Mapping, error)
+	Put(m Mapping) error
+	Delete(m Mapping) error
+	// Each calls f with every mapping in ascending order of the source channel and timestamp.
+	// It stops at the first error from f and returns it. f must not modify the store.
+	Each(f func(m Mapping) error) error
+	// LatestTimeStamp returns the newest timestamp of the source messages from the channel
+	// which have been posted to the timeline, or empty string if there is none.
+	// It is kept even if the mapping of the message has been deleted or pruned.
+	LatestTimeStamp(sourceChannelID string) (string, error)
+}
+
+// backendMappingStore stores mappings as JSON in the backend.
+type backendMappingStore struct {
+	backend Backend
+}
+
+func NewMappingStore(b Backend) MappingStore {
+	return backendMappingStore{backend: b}
+}
+
+func (s backendMappingStore) Get(sourceChannelID, sourceTimeStamp, timelineChannelID string) (*Mapping, error) {
+	k := MappingKey(sourceChannelID, sourceTimeStamp, timelineChannelID)
+	data, e := s.backend.Get(mappingKeyPrefix + k)
+	if e != nil {
+		return nil, errors.Wrap(e, "failed to read mapping of "+k)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	m, e := DecodeMapping(k, data)
+	if e != nil {
+		return nil, e
+	}
+	return &m, nil
+}
+
+func (s backendMappingStore) Find(sourceChannelID, sourceTimeStamp string) ([]Mapping, error) {
+	ms := []Mapping{}
+	e := s.scan(mappingKeyPrefix+MappingKey(sourceChannelID, sourceTimeStamp, ""), func(m Mapping) error {
+		ms = append(ms, m)
+		return nil
+	})
+	return ms, e
+}
+
+func (s backendMappingStore) Put(m Mapping) error {
+	data, e := m.Encode()
+	if e != nil {
+		return e
+	}
+	if e := s.backend.Put(mappingKeyPrefix+m.Key(), data); e != nil {
+		return errors.Wrap(e, "failed to write mapping of "+m.Key())
+	}
+	return s.putLatestTimeStamp(m.SourceChannelID, m.SourceTimeStamp)
+}
+
+// putLatestTimeStamp records the timestamp as the newest one of the channel unless a newer one is recorded.
+// Timestamps are compared as strings like the keys of mappings.
+func (s backendMappingStore) putLatestTimeStamp(sourceChannelID, sourceTimeStamp string) error {
+	k := latestKeyPrefix + sourceChannelID
+	latest, e := s.backend.Get(k)
+	if e != nil {
+		return errors.Wrap(e, "failed to read latest timestamp of "+sourceChannelID)
+	}
+	if string(latest) >= sourceTimeStamp {
+		return nil
+	}
+	if e := s.backend.Put(k, []byte(sourceTimeStamp)); e != nil {
+		return errors.Wrap(e, "failed to write latest timestamp of "+sourceChannelID)
+	}
+	return nil
+}
+
+func (s backendMappingStore) Delete(m Mapping) error {
+	if e := s.backend.Delete(mappingKeyPrefix + m.Key()); e != nil {
+		return errors.Wrap(e, "failed to delete mapping of "+m.Key())
+	}
+	return nil
+}
+
+func (s backendMappingStore) Each(f func(m Mapping) error) error {
+	return s.scan(mappingKeyPrefix, f)
+}
+
+// scan calls f with the mappings whose keys start with prefix.
+// Records which cannot be decoded are skipped so that one broken record does not hide the others.
+func (s backendMappingStore) scan(prefix string, f func(m Mapping) error) error {
+	return s.backend.Scan(prefix, func(k string, v []byte) error {
+		m, e := DecodeMapping(strings.TrimPrefix(k, mappingKeyPrefix), v)
+		if e != nil {
+			return nil
+		}
+		return f(m)
+	})
+}
+
+// LatestTimeStamp returns the recorded timestamp, or the timestamp of the newest mapping
+// if it is newer, as stores written by older versions have no recorded ones.
+func (s backendMappingStore) LatestTimeStamp(sourceChannelID string) (string, error) {
+	latest, e := s.backend.Get(latestKeyPrefix + sourceChannelID)
+	if e != nil {
+		return "", errors.Wrap(e, "failed to read latest timestamp of "+sourceChannelID)
+	}
+	prefix := mappingKeyPrefix + sourceChannelID + "-"
+	k, e := s.backend.LastKey(prefix)
+	if e != nil || k == "" {
+		return string(latest), e
+	}
+	ts := k[len(prefix):]
+	if i := strings.Index(ts, "-"); i >= 0 {
+		ts = ts[:i]
+	}
+	if string(latest) > ts {
+		return string(latest), nil
+	}
+	return ts, nil
+}
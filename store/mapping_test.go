@@ -0,0 +1,159 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ara-ta3/slack-timeline/timeline"
+)
+
+func TestMigrateConvertsLegacyMappings(t *testing.T) {
+	s := NewMemoryStore()
+	current := Mapping{
+		Version:           MappingVersion,
+		SourceChannelID:   "C1",
+		SourceTimeStamp:   "2.0",
+		TimelineChannelID: "CT",
+		TimelineTimeStamp: "8.0",
+		PostedAt:          time.Unix(8, 0).UTC(),
+	}
+	data, _ := current.Encode()
+	s.Put("mapping:"+current.Key(), data)
+	s.Put("C1-1.0", []byte(`{"ok":true,"channel":"CT","ts":"9.5","message":{"user":"U1"}}`))
+	s.Put("C2-3.0-CA", []byte(`{"ok":true,"channel":"CA","ts":"7.0"}`))
+	s.Put("C3-4.0-CT", []byte(`{"version":1,"sourceChannelID":"C3","sourceTs":"4.0","timelineChannelID":"CT","timelineTs":"9.9"}`))
+	s.Put("user:U1", []byte(`{"user":{"ID":"U1"},"cachedAt":"2020-01-01T00:00:00Z"}`))
+	s.Put("C4-5.0", []byte(`{"ok":false,"error":"channel_not_found"}`))
+
+	n, e := Migrate(s)
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, 3, n)
+
+	keys := []string{}
+	s.Scan("", func(k string, v []byte) error {
+		keys = append(keys, k)
+		return nil
+	})
+	assert.Equal(t, []string{
+		"latest:C1", "latest:C2", "latest:C3",
+		"mapping:C1-1.0-CT", "mapping:C1-2.0-CT", "mapping:C2-3.0-CA", "mapping:C3-4.0-CT",
+		"user:U1",
+	}, keys)
+
+	m, e := NewMappingStore(s).Get("C1", "1.0", "CT")
+	if assert.NoError(t, e) && assert.NotNil(t, m) {
+		assert.Equal(t, MappingVersion, m.Version)
+		assert.Equal(t, "9.5", m.TimelineTimeStamp)
+		assert.Equal(t, "U1", m.UserID)
+		assert.Equal(t, int64(9), m.PostedAt.Unix())
+	}
+
+	n, e = Migrate(s)
+	if assert.NoError(t, e) {
+		assert.Equal(t, 0, n)
+	}
+}
+
+func TestMappingReaderReadsLegacyMappingsAsTheyAre(t *testing.T) {
+	b := NewMemoryStore()
+	NewMappingStore(b).Put(Mapping{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CT"})
+	b.Put("C2-2.0", []byte(`{"ok":true,"channel":"CT","ts":"9.5"}`))
+	b.Put("C3-3.0", []byte(`{"ok":false,"error":"channel_not_found"}`))
+
+	versions := []int{}
+	assert.NoError(t, NewMappingReader(b).Each(func(m Mapping) error {
+		versions = append(versions, m.Version)
+		return nil
+	}))
+	assert.Equal(t, []int{MappingVersion, 0}, versions)
+	data, _ := b.Get("C3-3.0")
+	assert.NotNil(t, data)
+}
+
+func TestMappingStore(t *testing.T) {
+	b := NewMemoryStore()
+	s := NewMappingStore(b)
+	for _, m := range []Mapping{
+		{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CT", TimelineTimeStamp: "9.1"},
+		{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CA", TimelineTimeStamp: "9.2"},
+		{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "2.0", TimelineChannelID: "CT", TimelineTimeStamp: "9.3"},
+		{Version: MappingVersion, SourceChannelID: "C10", SourceTimeStamp: "3.0", TimelineChannelID: "CT", TimelineTimeStamp: "9.4"},
+	} {
+		assert.NoError(t, s.Put(m))
+	}
+	b.Put("user:U1", []byte(`{"user":{"ID":"U1"}}`))
+	b.Put("mapping:C1-5.0-CT", []byte(`broken`))
+
+	m, e := s.Get("C1", "1.0", "CA")
+	if assert.NoError(t, e) && assert.NotNil(t, m) {
+		assert.Equal(t, "9.2", m.TimelineTimeStamp)
+	}
+	m, e = s.Get("C1", "1.0", "CX")
+	assert.NoError(t, e)
+	assert.Nil(t, m)
+
+	ms, e := s.Find("C1", "1.0")
+	if assert.NoError(t, e) {
+		assert.Equal(t, []string{"C1-1.0-CA", "C1-1.0-CT"}, keysOf(ms))
+	}
+
+	n := 0
+	assert.NoError(t, s.Each(func(m Mapping) error {
+		n++
+		return nil
+	}))
+	assert.Equal(t, 4, n)
+
+	ts, e := s.LatestTimeStamp("C1")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "5.0", ts)
+	}
+	ts, e = s.LatestTimeStamp("C2")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "", ts)
+	}
+
+	assert.NoError(t, s.Delete(Mapping{SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CA"}))
+	ms, _ = s.Find("C1", "1.0")
+	assert.Equal(t, []string{"C1-1.0-CT"}, keysOf(ms))
+}
+
+func TestLatestTimeStampIsKeptAfterMappingsAreDeleted(t *testing.T) {
+	b := NewMemoryStore()
+	s := NewMappingStore(b)
+	older := Mapping{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CT"}
+	newer := Mapping{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "2.0", TimelineChannelID: "CT"}
+	assert.NoError(t, s.Put(newer))
+	assert.NoError(t, s.Put(older))
+	assert.NoError(t, s.Delete(newer))
+	assert.NoError(t, s.Delete(older))
+
+	ts, e := s.LatestTimeStamp("C1")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "2.0", ts)
+	}
+
+	n := 0
+	assert.NoError(t, s.Each(func(m Mapping) error {
+		n++
+		return nil
+	}))
+	assert.Equal(t, 0, n)
+}
+
+func TestDecodeMappingFailsAsSkippable(t *testing.T) {
+	for _, data := range []string{`broken`, `{"ok":false,"error":"channel_not_found"}`} {
+		_, e := DecodeMapping("C1-1.0", []byte(data))
+		if assert.Error(t, e, data) {
+			assert.Equal(t, timeline.ErrorSkippable, timeline.KindOf(e))
+		}
+	}
+	s := NewMemoryStore()
+	s.Put("mapping:C1-1.0-CT", []byte(`broken`))
+	_, e := NewMappingStore(s).Get("C1", "1.0", "CT")
+	assert.Equal(t, timeline.ErrorSkippable, timeline.KindOf(e))
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore is the backend on memory, which is lost on exit.
+type MemoryStore struct {
+	mutex *sync.RWMutex
+	data  map[string][]byte
+}
+
+func NewMemoryStore() MemoryStore {
+	return MemoryStore{
+		mutex: &sync.RWMutex{},
+		data:  map[string][]byte{},
+	}
+}
+
+func (s MemoryStore) Get(key string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	v, found := s.data[key]
+	if !found {
+		return nil, nil
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (s MemoryStore) Put(key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (s MemoryStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s MemoryStore) Scan(prefix string, f func(key string, value []byte) error) error {
+	for _, k := range s.keys(prefix) {
+		v, _ := s.Get(k)
+		if v == nil {
+			continue
+		}
+		if e := f(k, v); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s MemoryStore) LastKey(prefix string) (string, error) {
+	ks := s.keys(prefix)
+	if len(ks) == 0 {
+		return "", nil
+	}
+	return ks[len(ks)-1], nil
+}
+
+// keys returns the sorted keys which start with prefix.
+func (s MemoryStore) keys(prefix string) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ks := []string{}
+	for k := range s.data {
+		if strings.HasPrefix(k, prefix) {
+			ks = append(ks, k)
+		}
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func (s MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Compactor is a backend which can reclaim the space of deleted records.
+type Compactor interface {
+	Compact() error
+}
+
+// Pruner removes the mappings posted before Retention from the store periodically,
+// so that the store does not grow forever. Records other than mappings are kept.
+type Pruner struct {
+	backend   Backend
+	mappings  MappingStore
+	retention time.Duration
+	interval  time.Duration
+	logger    *log.Logger
+	now       func() time.Time
+}
+
+func NewPruner(b Backend, retention, interval time.Duration, logger *log.Logger) Pruner {
+	return Pruner{
+		backend:   b,
+		mappings:  NewMappingStore(b),
+		retention: retention,
+		interval:  interval,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Run prunes the store every interval. It does not return.
+func (p Pruner) Run() {
+	for {
+		n, e := p.Prune()
+		if e != nil {
+			p.logger.Printf("failed to prune db: %+v\n", e)
+		} else {
+			p.logger.Printf("pruned %d messages older than %s from db\n", n, p.retention)
+		}
+		time.Sleep(p.interval)
+	}
+}
+
+// Prune removes the expired mappings and compacts the store if it can,
+// and returns the number of removed mappings.
+func (p Pruner) Prune() (int, error) {
+	threshold := p.now().Add(-p.retention)
+	expired := []Mapping{}
+	e := p.mappings.Each(func(m Mapping) error {
+		if !m.PostedAt.IsZero() && m.PostedAt.Before(threshold) {
+			expired = append(expired, m)
+		}
+		return nil
+	})
+	if e != nil {
+		return 0, errors.Wrap(e, "failed to scan mappings to prune")
+	}
+	for i, m := range expired {
+		if e := p.mappings.Delete(m); e != nil {
+			return i, e
+		}
+	}
+	if c, ok := p.backend.(Compactor); ok && len(expired) > 0 {
+		if e := c.Compact(); e != nil {
+			return len(expired), errors.Wrap(e, "failed to compact db")
+		}
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// compactCounter counts how many times the store was compacted.
+type compactCounter struct {
+	MemoryStore
+	count *int
+}
+
+func (c compactCounter) Compact() error {
+	*c.count++
+	return nil
+}
+
+func TestPrunerRemovesExpiredMappings(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	count := 0
+	s := compactCounter{MemoryStore: NewMemoryStore(), count: &count}
+	for _, m := range []Mapping{
+		{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "1.0", TimelineChannelID: "CT", PostedAt: now.Add(-48 * time.Hour)},
+		{Version: MappingVersion, SourceChannelID: "C1", SourceTimeStamp: "2.0", TimelineChannelID: "CT", PostedAt: now.Add(-time.Hour)},
+		{Version: MappingVersion, SourceChannelID: "C2", SourceTimeStamp: "3.0", TimelineChannelID: "CT", PostedAt: now.Add(-48 * time.Hour)},
+	} {
+		NewMappingStore(s).Put(m)
+	}
+	s.Put("user:U1", []byte(`{"user":{"ID":"U1"},"cachedAt":"2000-01-01T00:00:00Z"}`))
+	p := NewPruner(s, 24*time.Hour, time.Hour, log.New(ioutil.Discard, "", 0))
+	p.now = func() time.Time { return now }
+
+	n, e := p.Prune()
+	if assert.NoError(t, e) {
+		assert.Equal(t, 2, n)
+	}
+	keys := []string{}
+	s.Scan("", func(k string, v []byte) error {
+		keys = append(keys, k)
+		return nil
+	})
+	assert.Equal(t, []string{"latest:C1", "latest:C2", "mapping:C1-2.0-CT", "user:U1"}, keys)
+	assert.Equal(t, 1, count)
+	ts, e := NewMappingStore(s).LatestTimeStamp("C2")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "3.0", ts)
+	}
+
+	n, e = p.Prune()
+	if assert.NoError(t, e) {
+		assert.Equal(t, 0, n)
+	}
+	assert.Equal(t, 1, count)
+}
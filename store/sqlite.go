@@ -0,0 +1,116 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	// register the driver of sqlite3
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// SQLiteStore is the backend in a table of SQLite.
+// Keys are compared by their bytes as the table uses the BINARY collation.
+type SQLiteStore struct {
+	db   *sql.DB
+	lock *os.File
+}
+
+// OpenSQLiteStore opens the file, which fails if another process has opened it.
+// It is locked by the file with ".lock" suffix as SQLite allows other processes to open it.
+// It fails if the binary was built without cgo.
+func OpenSQLiteStore(path string) (SQLiteStore, error) {
+	if !sqliteAvailable {
+		return SQLiteStore{}, errors.New("sqlite is not supported by this binary, which was built without cgo: " + path)
+	}
+	lock, e := lockFile(path + ".lock")
+	if e != nil {
+		return SQLiteStore{}, e
+	}
+	db, e := sql.Open("sqlite3", path)
+	if e != nil {
+		lock.Close()
+		return SQLiteStore{}, errors.Wrap(e, "failed to open sqlite "+path)
+	}
+	_, e = db.Exec("CREATE TABLE IF NOT EXISTS mappings (key TEXT PRIMARY KEY, value BLOB NOT NULL)")
+	if e != nil {
+		db.Close()
+		lock.Close()
+		return SQLiteStore{}, errors.Wrap(e, "failed to create table in "+path)
+	}
+	return SQLiteStore{db: db, lock: lock}, nil
+}
+
+func (s SQLiteStore) Get(key string) ([]byte, error) {
+	var v []byte
+	e := s.db.QueryRow("SELECT value FROM mappings WHERE key = ?", key).Scan(&v)
+	if e == sql.ErrNoRows {
+		return nil, nil
+	}
+	return v, e
+}
+
+func (s SQLiteStore) Put(key string, value []byte) error {
+	_, e := s.db.Exec("INSERT OR REPLACE INTO mappings (key, value) VALUES (?, ?)", key, value)
+	return e
+}
+
+func (s SQLiteStore) Delete(key string) error {
+	_, e := s.db.Exec("DELETE FROM mappings WHERE key = ?", key)
+	return e
+}
+
+func (s SQLiteStore) Scan(prefix string, f func(key string, value []byte) error) error {
+	rows, e := s.query("SELECT key, value FROM mappings WHERE %s ORDER BY key", prefix)
+	if e != nil {
+		return e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k string
+		var v []byte
+		if e := rows.Scan(&k, &v); e != nil {
+			return e
+		}
+		if e := f(k, v); e != nil {
+			return e
+		}
+	}
+	return rows.Err()
+}
+
+func (s SQLiteStore) LastKey(prefix string) (string, error) {
+	rows, e := s.query("SELECT key FROM mappings WHERE %s ORDER BY key DESC LIMIT 1", prefix)
+	if e != nil {
+		return "", e
+	}
+	defer rows.Close()
+	k := ""
+	if rows.Next() {
+		if e := rows.Scan(&k); e != nil {
+			return "", e
+		}
+	}
+	return k, rows.Err()
+}
+
+// query runs the query whose %s is replaced with the condition of keys starting with prefix.
+func (s SQLiteStore) query(query, prefix string) (*sql.Rows, error) {
+	end := prefixEnd(prefix)
+	if end == nil {
+		return s.db.Query(fmt.Sprintf(query, "key >= ?"), prefix)
+	}
+	return s.db.Query(fmt.Sprintf(query, "key >= ? AND key < ?"), prefix, string(end))
+}
+
+// Compact rebuilds the database file to reclaim the space of deleted records.
+func (s SQLiteStore) Compact() error {
+	_, e := s.db.Exec("VACUUM")
+	return e
+}
+
+func (s SQLiteStore) Close() error {
+	defer s.lock.Close()
+	return s.db.Close()
+}
@@ -0,0 +1,6 @@
+//go:build cgo
+
+package store
+
+// sqliteAvailable tells whether the driver of sqlite3 works, which needs cgo.
+const sqliteAvailable = true
@@ -0,0 +1,7 @@
+//go:build !cgo
+
+package store
+
+// sqliteAvailable tells whether the driver of sqlite3 works, which needs cgo.
+// Without cgo the driver is a stub which fails on every query.
+const sqliteAvailable = false
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Backend is the ordered key-value store which the records of SlackTimeline are written to.
+// Each kind of records such as mappings and cached users has its own prefix of keys.
+// Keys are ordered by their bytes.
+type Backend interface {
+	// Get returns the value of the key, or nil if it is not found.
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	Delete(key string) error
+	// Scan calls f with the keys which start with prefix and their values in ascending order of keys.
+	// It stops at the first error from f and returns it. f must not modify the store.
+	Scan(prefix string, f func(key string, value []byte) error) error
+	// LastKey returns the greatest key which starts with prefix, or empty string if there is none.
+	LastKey(prefix string) (string, error)
+	Close() error
+}
+
+// Open opens the backend of the URL, whose scheme is the kind of the backend.
+// leveldb://path, bolt://path, sqlite://path and memory:// are supported,
+// and a path without scheme is opened with LevelDB.
+func Open(url string) (Backend, error) {
+	scheme, path := splitURL(url)
+	var s Backend
+	var e error
+	switch scheme {
+	case "leveldb":
+		s, e = OpenLevelDBStore(path)
+	case "bolt":
+		s, e = OpenBoltStore(path)
+	case "sqlite":
+		s, e = OpenSQLiteStore(path)
+	case "memory":
+		s = NewMemoryStore()
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown scheme of db: %s", url))
+	}
+	if e != nil {
+		return nil, e
+	}
+	return s, nil
+}
+
+// OpenExisting opens the backend of the URL like Open, but fails if it does not exist
+// instead of creating an empty one.
+func OpenExisting(url string) (Backend, error) {
+	scheme, path := splitURL(url)
+	if scheme == "memory" {
+		return Open(url)
+	}
+	// LevelDB makes the directory even if it fails for ErrorIfMissing
+	if _, e := os.Stat(path); e != nil {
+		return nil, errors.Wrap(e, "failed to open db "+url)
+	}
+	if scheme == "leveldb" {
+		s, e := OpenExistingLevelDBStore(path)
+		if e != nil {
+			return nil, e
+		}
+		return s, nil
+	}
+	return Open(url)
+}
+
+// splitURL returns the scheme and the path of the URL of the backend.
+// The scheme is leveldb if the URL has none.
+func splitURL(url string) (string, string) {
+	if i := strings.Index(url, "://"); i >= 0 {
+		return url[:i], url[i+len("://"):]
+	}
+	return "leveldb", url
+}
+
+// prefixEnd returns the least key which is greater than all keys starting with prefix,
+// or nil if there is no such key.
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
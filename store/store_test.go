@@ -0,0 +1,205 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// testBackend is the conformance test which every backend has to pass.
+func testBackend(t *testing.T, open func(t *testing.T) Backend) {
+	t.Run("GetPutDelete", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		v, e := s.Get("C1-1.0")
+		assert.NoError(t, e)
+		assert.Nil(t, v)
+
+		assert.NoError(t, s.Put("C1-1.0", []byte("first")))
+		assert.NoError(t, s.Put("C1-1.0", []byte("second")))
+		v, e = s.Get("C1-1.0")
+		if assert.NoError(t, e) {
+			assert.Equal(t, []byte("second"), v)
+		}
+
+		assert.NoError(t, s.Delete("C1-1.0"))
+		assert.NoError(t, s.Delete("C1-1.0"))
+		v, e = s.Get("C1-1.0")
+		assert.NoError(t, e)
+		assert.Nil(t, v)
+	})
+
+	t.Run("ScanAndLastKey", func(t *testing.T) {
+		s := open(t)
+		defer s.Close()
+		for _, k := range []string{"C2-1.0", "C1-3.0-CT", "C10-9.0", "C1-1.0", "user:U1", "C1-\xff"} {
+			assert.NoError(t, s.Put(k, []byte("v-"+k)))
+		}
+
+		keys := []string{}
+		e := s.Scan("C1-", func(k string, v []byte) error {
+			assert.Equal(t, "v-"+k, string(v))
+			keys = append(keys, k)
+			return nil
+		})
+		if assert.NoError(t, e) {
+			assert.Equal(t, []string{"C1-1.0", "C1-3.0-CT", "C1-\xff"}, keys)
+		}
+
+		all := 0
+		assert.NoError(t, s.Scan("", func(k string, v []byte) error {
+			all++
+			return nil
+		}))
+		assert.Equal(t, 6, all)
+
+		stop := errors.New("stop")
+		n := 0
+		assert.Equal(t, stop, s.Scan("", func(k string, v []byte) error {
+			n++
+			return stop
+		}))
+		assert.Equal(t, 1, n)
+
+		for prefix, expected := range map[string]string{
+			"C1-":   "C1-\xff",
+			"C1":    "C10-9.0",
+			"":      "user:U1",
+			"user:": "user:U1",
+			"C3-":   "",
+		} {
+			k, e := s.LastKey(prefix)
+			if assert.NoError(t, e) {
+				assert.Equal(t, expected, k, "prefix %q", prefix)
+			}
+		}
+	})
+}
+
+func TestLevelDBStore(t *testing.T) {
+	testBackend(t, func(t *testing.T) Backend {
+		db, e := leveldb.Open(storage.NewMemStorage(), nil)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return NewLevelDBStore(db)
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	testBackend(t, func(t *testing.T) Backend {
+		s, e := OpenBoltStore(filepath.Join(t.TempDir(), "timeline.bolt"))
+		if e != nil {
+			t.Fatal(e)
+		}
+		return s
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	if !sqliteAvailable {
+		t.Skip("sqlite needs cgo")
+	}
+	testBackend(t, func(t *testing.T) Backend {
+		s, e := OpenSQLiteStore(filepath.Join(t.TempDir(), "timeline.sqlite"))
+		if e != nil {
+			t.Fatal(e)
+		}
+		return s
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testBackend(t, func(t *testing.T) Backend {
+		return NewMemoryStore()
+	})
+}
+
+func TestOpenByScheme(t *testing.T) {
+	dir := t.TempDir()
+	urls := []string{
+		filepath.Join(dir, "plain"),
+		"leveldb://" + filepath.Join(dir, "leveldb"),
+		"bolt://" + filepath.Join(dir, "bolt"),
+		"memory://",
+	}
+	if sqliteAvailable {
+		urls = append(urls, "sqlite://"+filepath.Join(dir, "sqlite"))
+	}
+	for _, url := range urls {
+		s, e := Open(url)
+		if assert.NoError(t, e, url) {
+			assert.NoError(t, s.Put("k", []byte("v")))
+			assert.NoError(t, s.Close())
+		}
+	}
+	_, e := Open("redis://localhost")
+	assert.Error(t, e)
+}
+
+func TestOpenExistingDoesNotCreateDB(t *testing.T) {
+	dir := t.TempDir()
+	urls := []string{
+		filepath.Join(dir, "plain"),
+		"leveldb://" + filepath.Join(dir, "leveldb"),
+		"bolt://" + filepath.Join(dir, "bolt"),
+		"sqlite://" + filepath.Join(dir, "sqlite"),
+	}
+	for _, url := range urls {
+		_, e := OpenExisting(url)
+		assert.Error(t, e, url)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	assert.Empty(t, files)
+	_, e := OpenExisting("leveldb://" + dir)
+	assert.Error(t, e)
+
+	for _, url := range urls[:3] {
+		s, e := Open(url)
+		if assert.NoError(t, e, url) {
+			assert.NoError(t, s.Close())
+		}
+		s, e = OpenExisting(url)
+		if assert.NoError(t, e, url) {
+			assert.NoError(t, s.Close())
+		}
+	}
+}
+
+func TestOpenSQLiteWithoutCgo(t *testing.T) {
+	if sqliteAvailable {
+		t.Skip("sqlite is available")
+	}
+	_, e := Open("sqlite://" + filepath.Join(t.TempDir(), "sqlite"))
+	assert.Error(t, e)
+}
+
+func TestOpenFailsWhileLocked(t *testing.T) {
+	dir := t.TempDir()
+	urls := []string{
+		"leveldb://" + filepath.Join(dir, "leveldb"),
+		"bolt://" + filepath.Join(dir, "bolt"),
+	}
+	if sqliteAvailable {
+		urls = append(urls, "sqlite://"+filepath.Join(dir, "sqlite"))
+	}
+	for _, url := range urls {
+		s, e := Open(url)
+		if !assert.NoError(t, e, url) {
+			continue
+		}
+		_, e = Open(url)
+		assert.Equal(t, ErrLocked, e, url)
+		s.Close()
+
+		s, e = Open(url)
+		if assert.NoError(t, e, url) {
+			s.Close()
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package timeline
+
+import "strings"
+
+// Attachment is a legacy attachment of a message, which integrations often post instead of text.
+type Attachment struct {
+	Pretext   string
+	Title     string
+	TitleLink string
+	Text      string
+	Fallback  string
+}
+
+// Summary returns the text of the attachment in mrkdwn, or Fallback if it has nothing else.
+// The title is escaped like File.LinkLabel when it is the label of TitleLink.
+func (a Attachment) Summary() string {
+	lines := []string{}
+	if a.Pretext != "" {
+		lines = append(lines, a.Pretext)
+	}
+	if a.Title != "" && a.TitleLink != "" {
+		lines = append(lines, "*<"+a.TitleLink+"|"+linkLabelEscaper.Replace(a.Title)+">*")
+	} else if a.Title != "" {
+		lines = append(lines, "*"+a.Title+"*")
+	}
+	if a.Text != "" {
+		lines = append(lines, a.Text)
+	}
+	if len(lines) == 0 {
+		return a.Fallback
+	}
+	return strings.Join(lines, "\n")
+}
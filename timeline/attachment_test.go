@@ -0,0 +1,23 @@
+package timeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachmentSummary(t *testing.T) {
+	a := Attachment{Pretext: "deployed", Title: "Build #1", TitleLink: "https://example.com/1", Text: "passed"}
+	assert.Equal(t, "deployed\n*<https://example.com/1|Build #1>*\npassed", a.Summary())
+
+	a = Attachment{Title: "Build #1"}
+	assert.Equal(t, "*Build #1*", a.Summary())
+
+	a = Attachment{Fallback: "Build #1 passed"}
+	assert.Equal(t, "Build #1 passed", a.Summary())
+}
+
+func TestAttachmentSummaryEscapesTitleOfLink(t *testing.T) {
+	a := Attachment{Title: "a < b & c > d | e", TitleLink: "https://example.com/1"}
+	assert.Equal(t, "*<https://example.com/1|a &lt; b &amp; c &gt; d ¦ e>*", a.Summary())
+}
@@ -0,0 +1,13 @@
+package timeline
+
+type Channel struct {
+	ID   string
+	Name string
+}
+
+func NewChannel(id, name string) Channel {
+	return Channel{
+		ID:   id,
+		Name: name,
+	}
+}
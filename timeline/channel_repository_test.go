@@ -0,0 +1,21 @@
+package timeline
+
+type ChannelRepositoryOnMemory struct {
+	data map[string]Channel
+}
+
+func (r ChannelRepositoryOnMemory) Get(channelID string) (*Channel, error) {
+	c, found := r.data[channelID]
+	if found {
+		return &c, nil
+	}
+	return nil, nil
+}
+
+func (r ChannelRepositoryOnMemory) GetAll() ([]Channel, error) {
+	cs := []Channel{}
+	for _, c := range r.data {
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
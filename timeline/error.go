@@ -0,0 +1,40 @@
+package timeline
+
+// ErrorKind tells the service what to do with an error while handling an event.
+type ErrorKind int
+
+const (
+	// ErrorFatal stops the service. Errors which do not tell their kind are fatal.
+	ErrorFatal ErrorKind = iota
+	// ErrorRetryable is temporary, so the event is handled again.
+	ErrorRetryable
+	// ErrorSkippable fails only the event, which is logged and skipped.
+	ErrorSkippable
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorRetryable:
+		return "retryable"
+	case ErrorSkippable:
+		return "skippable"
+	default:
+		return "fatal"
+	}
+}
+
+// KindOf returns the kind of the error, which is told by the Kind method of the error
+// or the errors it wraps.
+func KindOf(e error) ErrorKind {
+	for e != nil {
+		if k, ok := e.(interface{ Kind() ErrorKind }); ok {
+			return k.Kind()
+		}
+		c, ok := e.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		e = c.Cause()
+	}
+	return ErrorFatal
+}
@@ -0,0 +1,16 @@
+package timeline
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindOfLooksIntoWrappedErrors(t *testing.T) {
+	assert.Equal(t, ErrorSkippable, KindOf(errors.Wrap(errors.Wrap(kindError(ErrorSkippable), "a"), "b")))
+	assert.Equal(t, ErrorRetryable, KindOf(kindError(ErrorRetryable)))
+	assert.Equal(t, ErrorFatal, KindOf(fmt.Errorf("unknown")))
+	assert.Equal(t, ErrorFatal, KindOf(errors.New("unknown")))
+}
@@ -0,0 +1,35 @@
+package timeline
+
+import "strings"
+
+// File is a file shared with a message.
+type File struct {
+	ID        string
+	Name      string
+	Title     string
+	MimeType  string
+	Permalink string
+	// Thumbnail is the URL of the preview of an image, which is empty for other files.
+	Thumbnail string
+}
+
+// Label returns the title of the file, or the name if it has no title.
+func (f File) Label() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return f.Name
+}
+
+// linkLabelEscaper escapes the characters which break the label of a link in Slack's mrkdwn.
+var linkLabelEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "|", "\u00a6")
+
+// LinkLabel returns Label escaped to be the label of a link like <Permalink|LinkLabel>.
+// "|" is replaced with the broken bar "¦" as Slack has no escape for it.
+func (f File) LinkLabel() string {
+	return linkLabelEscaper.Replace(f.Label())
+}
+
+func (f File) IsImage() bool {
+	return strings.HasPrefix(f.MimeType, "image/")
+}
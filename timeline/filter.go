@@ -0,0 +1,165 @@
+package timeline
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// MessageFilter is a rule for the content of messages posted to the timeline.
+type MessageFilter interface {
+	// Reject returns why the message should not be posted, or empty string if it can be posted.
+	Reject(m *Message) string
+}
+
+// MessageTrimmer is a filter which also removes the parts of messages which should not be posted.
+type MessageTrimmer interface {
+	// Trim removes the parts from the message which was accepted.
+	Trim(m *Message)
+}
+
+// TextFilter accepts messages whose text matches any of Include and none of Exclude.
+// Every message matches Include when it is empty.
+type TextFilter struct {
+	Include []*regexp.Regexp
+	Exclude []*regexp.Regexp
+}
+
+func NewTextFilter(include, exclude []string) (TextFilter, error) {
+	in, e := compileAll(include)
+	if e != nil {
+		return TextFilter{}, e
+	}
+	ex, e := compileAll(exclude)
+	if e != nil {
+		return TextFilter{}, e
+	}
+	return TextFilter{
+		Include: in,
+		Exclude: ex,
+	}, nil
+}
+
+func (f TextFilter) Reject(m *Message) string {
+	if len(f.Include) > 0 && !matchAnyRegexp(f.Include, m.Text) {
+		return "text matches none of include patterns"
+	}
+	for _, r := range f.Exclude {
+		if r.MatchString(m.Text) {
+			return fmt.Sprintf("text matches exclude pattern %s", r)
+		}
+	}
+	return ""
+}
+
+// LengthFilter accepts messages whose number of characters is between Min and Max.
+// Max of 0 means no limit.
+type LengthFilter struct {
+	Min int
+	Max int
+}
+
+func (f LengthFilter) Reject(m *Message) string {
+	n := utf8.RuneCountInString(m.Text)
+	if n < f.Min {
+		return fmt.Sprintf("text is shorter than %d characters", f.Min)
+	}
+	if f.Max > 0 && n > f.Max {
+		return fmt.Sprintf("text is longer than %d characters", f.Max)
+	}
+	return ""
+}
+
+// UserFilter rejects messages from the users in DenyUserIDs.
+type UserFilter struct {
+	DenyUserIDs []string
+}
+
+func (f UserFilter) Reject(m *Message) string {
+	if contains(f.DenyUserIDs, m.UserID) {
+		return fmt.Sprintf("user %s is denied", m.UserID)
+	}
+	return ""
+}
+
+// BotFilter rejects messages posted by bots and integrations.
+// When AllowBotIDs is set, only the messages of the bots in it are accepted.
+// When DenyBotIDs is set, the messages of the bots in it are rejected.
+// All messages of bots are rejected if both are empty, unless AllowAll is true.
+type BotFilter struct {
+	AllowBotIDs []string
+	DenyBotIDs  []string
+	AllowAll    bool
+}
+
+func (f BotFilter) Reject(m *Message) string {
+	if m.BotID == "" {
+		return ""
+	}
+	if len(f.AllowBotIDs) == 0 && len(f.DenyBotIDs) == 0 && !f.AllowAll {
+		return fmt.Sprintf("posted by bot %s", m.BotID)
+	}
+	if len(f.AllowBotIDs) > 0 && !contains(f.AllowBotIDs, m.BotID) {
+		return fmt.Sprintf("posted by bot %s which is not allowed", m.BotID)
+	}
+	if contains(f.DenyBotIDs, m.BotID) {
+		return fmt.Sprintf("posted by denied bot %s", m.BotID)
+	}
+	return ""
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	rs := []*regexp.Regexp{}
+	for _, p := range patterns {
+		r, e := regexp.Compile(p)
+		if e != nil {
+			return nil, errors.Wrap(e, fmt.Sprintf("invalid pattern %s", p))
+		}
+		rs = append(rs, r)
+	}
+	return rs, nil
+}
+
+func matchAnyRegexp(rs []*regexp.Regexp, s string) bool {
+	for _, r := range rs {
+		if r.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileFilter drops the files whose mimetype matches any of ExcludeMimeTypes from messages,
+// which are glob patterns like "video/*".
+// It rejects the message if it has neither text nor files after that.
+// The files are dropped by Trim after the message was accepted.
+type FileFilter struct {
+	ExcludeMimeTypes []string
+}
+
+func (f FileFilter) Reject(m *Message) string {
+	if len(m.Files) == 0 || m.Text != "" {
+		return ""
+	}
+	if len(f.files(m.Files)) == 0 {
+		return "all files have excluded mimetypes"
+	}
+	return ""
+}
+
+func (f FileFilter) Trim(m *Message) {
+	m.Files = f.files(m.Files)
+}
+
+// files returns the files which are not excluded.
+func (f FileFilter) files(fs []File) []File {
+	files := []File{}
+	for _, file := range fs {
+		if !matchAny(f.ExcludeMimeTypes, file.MimeType) {
+			files = append(files, file)
+		}
+	}
+	return files
+}
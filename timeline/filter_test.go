@@ -0,0 +1,108 @@
+package timeline
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextFilter(t *testing.T) {
+	f, e := NewTextFilter([]string{"deploy", "release"}, []string{"^!"})
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, "", f.Reject(&Message{Text: "deploy finished"}))
+	assert.NotEqual(t, "", f.Reject(&Message{Text: "!deploy production"}))
+	assert.NotEqual(t, "", f.Reject(&Message{Text: "lunch?"}))
+}
+
+func TestTextFilterWithInvalidPattern(t *testing.T) {
+	_, e := NewTextFilter(nil, []string{"("})
+	assert.Error(t, e)
+}
+
+func TestLengthFilter(t *testing.T) {
+	f := LengthFilter{Min: 3, Max: 5}
+	assert.NotEqual(t, "", f.Reject(&Message{Text: "ok"}))
+	assert.Equal(t, "", f.Reject(&Message{Text: "おはよう"}))
+	assert.NotEqual(t, "", f.Reject(&Message{Text: "good morning"}))
+	assert.Equal(t, "", LengthFilter{Min: 1}.Reject(&Message{Text: "good morning"}))
+}
+
+func TestUserFilter(t *testing.T) {
+	f := UserFilter{DenyUserIDs: []string{"Unoisy"}}
+	assert.NotEqual(t, "", f.Reject(&Message{UserID: "Unoisy"}))
+	assert.Equal(t, "", f.Reject(&Message{UserID: "Uquiet"}))
+}
+
+func TestBotFilter(t *testing.T) {
+	assert.NotEqual(t, "", BotFilter{}.Reject(&Message{BotID: "B01"}))
+	assert.Equal(t, "", BotFilter{}.Reject(&Message{}))
+	assert.Equal(t, "", BotFilter{AllowAll: true}.Reject(&Message{BotID: "B01"}))
+}
+
+func TestIsTargetWithFiltersLogsReason(t *testing.T) {
+	out := &bytes.Buffer{}
+	v := MessageValidator{
+		TimelineChannelIDs: []string{"Ctimeline"},
+		Filters:            []MessageFilter{LengthFilter{Min: 3}, UserFilter{DenyUserIDs: []string{"Unoisy"}}},
+		DebugLogger:        log.New(out, "", 0),
+	}
+	assert.True(t, v.IsTargetMessage(&Message{ChannelID: "Cchannel", UserID: "Uquiet", Text: "hello"}))
+	assert.False(t, v.IsTargetMessage(&Message{ChannelID: "Cchannel", UserID: "Unoisy", Text: "hello", TimeStamp: "ts"}))
+	assert.Contains(t, out.String(), "Cchannel-ts: user Unoisy is denied")
+}
+
+func TestFileFilter(t *testing.T) {
+	f := FileFilter{ExcludeMimeTypes: []string{"video/*"}}
+	png := File{Name: "a.png", MimeType: "image/png"}
+	mp4 := File{Name: "b.mp4", MimeType: "video/mp4"}
+
+	m := Message{Text: "look", Files: []File{png, mp4}}
+	assert.Equal(t, "", f.Reject(&m))
+	assert.Equal(t, []File{png, mp4}, m.Files)
+	f.Trim(&m)
+	assert.Equal(t, []File{png}, m.Files)
+
+	onlyVideo := Message{Files: []File{mp4}}
+	assert.NotEqual(t, "", f.Reject(&onlyVideo))
+	assert.Equal(t, []File{mp4}, onlyVideo.Files)
+
+	withText := Message{Text: "look", Files: []File{mp4}}
+	assert.Equal(t, "", f.Reject(&withText))
+	f.Trim(&withText)
+	assert.Empty(t, withText.Files)
+}
+
+func TestBotFilterWithAllowAndDeny(t *testing.T) {
+	allow := BotFilter{AllowBotIDs: []string{"BCI"}}
+	assert.Equal(t, "", allow.Reject(&Message{BotID: "BCI"}))
+	assert.NotEqual(t, "", allow.Reject(&Message{BotID: "BOTHER"}))
+	assert.Equal(t, "", allow.Reject(&Message{}))
+
+	deny := BotFilter{DenyBotIDs: []string{"BNOISY"}}
+	assert.NotEqual(t, "", deny.Reject(&Message{BotID: "BNOISY"}))
+	assert.Equal(t, "", deny.Reject(&Message{BotID: "BCI"}))
+}
+
+func TestIsTargetRejectsOwnMessages(t *testing.T) {
+	v := MessageValidator{OwnUserID: "USELF", OwnBotID: "BSELF"}
+	assert.False(t, v.IsTargetMessage(&Message{ChannelID: "C1", UserID: "USELF"}))
+	assert.False(t, v.IsTargetMessage(&Message{ChannelID: "C1", BotID: "BSELF"}))
+	assert.True(t, v.IsTargetMessage(&Message{ChannelID: "C1", BotID: "BCI"}))
+}
+
+func TestLengthFilterCountsRewrittenMentions(t *testing.T) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"U0123456789": User{ID: "U0123456789", Name: "bob"},
+	}}
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+	s := NewServiceForTest(emptyWorker, userRepository, messageRepository, "Ctimeline", nil)
+	s.MessageValidator.Filters = []MessageFilter{LengthFilter{Max: 8}}
+
+	m := Message{Text: "hi <@U0123456789>", UserID: "U0123456789", ChannelID: "Cchannel", TimeStamp: "ts"}
+	assert.NoError(t, s.PutToTimeline(&m))
+	assert.Equal(t, "hi @\u200dbob", messageRepository.data[m.ToKey()].Text)
+}
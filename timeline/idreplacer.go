@@ -1,55 +1,172 @@
 package timeline
 
 import (
-	"fmt"
+	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// mentionMark is put before the names of mentions instead of bare "@".
+// The zero width joiner after "@" keeps them from notifying anyone even when Slack links names.
+const mentionMark = "@\u200d"
+
+// IDReplacer rewrites the mentions and references in Slack's format to plain text,
+// so that they are readable and do not notify anyone in the timeline.
+// Only the tokens enclosed in angle brackets are rewritten, and other text is kept as it is.
 type IDReplacer struct {
-	replacer  *strings.Replacer
-	IDToNames []string
+	userRepository UserRepository
+	userGroups     map[string]string
+	channels       map[string]string
 }
 
+// Replace rewrites the tokens in s.
+// Names of users are looked up on each call, so renamed users are picked up
+// as soon as the user repository has them.
 func (r IDReplacer) Replace(s string) string {
-	return r.replacer.Replace(s)
+	b := strings.Builder{}
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '>')
+		if end < 0 {
+			break
+		}
+		end += start
+		// the token starts at the last '<' before '>'
+		start += strings.LastIndexByte(s[start:end], '<')
+		b.WriteString(s[:start])
+		b.WriteString(r.rewrite(s[start : end+1]))
+		s = s[end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// rewrite returns the plain text of the token, or the token itself if it is not a mention.
+func (r IDReplacer) rewrite(token string) string {
+	content := token[1 : len(token)-1]
+	body, label := content, ""
+	if i := strings.IndexByte(content, '|'); i >= 0 {
+		body, label = content[:i], content[i+1:]
+	}
+	switch {
+	case strings.HasPrefix(body, "@"):
+		return mentionMark + r.userName(body[1:], label)
+	case strings.HasPrefix(body, "#"):
+		return "#" + nameOf(r.channels, body[1:], label)
+	case strings.HasPrefix(body, "!subteam^"):
+		return mentionMark + nameOf(r.userGroups, strings.TrimPrefix(body, "!subteam^"), label)
+	case body == "!here" || body == "!channel" || body == "!everyone":
+		return mentionMark + body[1:]
+	case strings.HasPrefix(body, "!date^"):
+		return dateText(strings.TrimPrefix(body, "!date^"), label, token)
+	}
+	return token
 }
 
-func NewIDReplacerFactory(r UserRepository) IDReplacerFactory {
+func (r IDReplacer) userName(id, label string) string {
+	if r.userRepository != nil {
+		u, e := r.userRepository.Get(id)
+		if e == nil && u != nil && u.Name != "" {
+			return u.Name
+		}
+	}
+	return fallbackName(id, label)
+}
+
+func nameOf(names map[string]string, id, label string) string {
+	if n, found := names[id]; found {
+		return n
+	}
+	return fallbackName(id, label)
+}
+
+// fallbackName returns the label of the token, which Slack adds with the name at the time,
+// or the ID if there is no label.
+func fallbackName(id, label string) string {
+	if l := strings.TrimLeft(label, "@#"); l != "" {
+		return l
+	}
+	return id
+}
+
+// dateText returns the fallback text of the date token,
+// or the time formatted by the timestamp in it if there is no fallback.
+func dateText(body, fallback, token string) string {
+	if fallback != "" {
+		return fallback
+	}
+	ts := body
+	if i := strings.IndexByte(body, '^'); i >= 0 {
+		ts = body[:i]
+	}
+	sec, e := strconv.ParseInt(ts, 10, 64)
+	if e != nil {
+		return token
+	}
+	return time.Unix(sec, 0).Format("2006-01-02 15:04")
+}
+
+// NewIDReplacerFactory returns the factory of replacers.
+// userGroupRepository and channelRepository can be nil, and then
+// the labels in the mentions are used instead of their current names.
+func NewIDReplacerFactory(r UserRepository, userGroupRepository UserGroupRepository, channelRepository ChannelRepository) IDReplacerFactory {
 	return IDReplacerFactory{
-		userRepository: r,
+		userRepository:      r,
+		userGroupRepository: userGroupRepository,
+		channelRepository:   channelRepository,
 	}
 }
 
 type IDReplacerFactory struct {
-	userRepository UserRepository
+	userRepository      UserRepository
+	userGroupRepository UserGroupRepository
+	channelRepository   ChannelRepository
+	// Logger logs the failures to get user groups and channels if it is not nil.
+	Logger *log.Logger
 }
 
+// NewReplacer returns the replacer with the current user groups and channels.
+// If they cannot be got, for example without usergroups:read scope, the failure is logged
+// and the labels or the IDs in the mentions are used instead of their names.
 func (f IDReplacerFactory) NewReplacer() (IDReplacer, error) {
-	us, e := f.userRepository.GetAll()
-	if e != nil {
-		e = errors.Wrap(e, "failed to get all from user repository")
-		return IDReplacer{}, e
+	r := newIDReplacer(f.userRepository)
+	if f.userGroupRepository != nil {
+		gs, e := f.userGroupRepository.GetAll()
+		if e != nil {
+			f.logError(errors.Wrap(e, "failed to get all from user group repository"))
+		}
+		for _, g := range gs {
+			r.userGroups[g.ID] = g.Handle
+		}
 	}
-
-	ns := make([]string, 2*len(us))
-	for i := 0; i < len(us); i++ {
-		u := us[i]
-		ns[2*i] = fmt.Sprintf("<@%s>", u.ID)
-		ns[2*i+1] = fmt.Sprintf("@%s", u.Name)
+	if f.channelRepository != nil {
+		cs, e := f.channelRepository.GetAll()
+		if e != nil {
+			f.logError(errors.Wrap(e, "failed to get all from channel repository"))
+		}
+		for _, c := range cs {
+			r.channels[c.ID] = c.Name
+		}
 	}
-	ms := []string{
-		"<!here|@here>",
-		"@here",
-		"<!channel>",
-		"@channel",
+	return r, nil
+}
+
+func (f IDReplacerFactory) logError(e error) {
+	if f.Logger != nil {
+		f.Logger.Printf("%+v\n", e)
 	}
-	replace := append(ns, ms...)
+}
 
-	r := strings.NewReplacer(replace...)
+func newIDReplacer(userRepository UserRepository) IDReplacer {
 	return IDReplacer{
-		replacer:  r,
-		IDToNames: ns,
-	}, nil
+		userRepository: userRepository,
+		userGroups:     map[string]string{},
+		channels:       map[string]string{},
+	}
 }
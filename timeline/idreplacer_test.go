@@ -1,6 +1,9 @@
 package timeline
 
 import (
+	"bytes"
+	"errors"
+	"log"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,10 +14,80 @@ func TestReplaceUserIDToName(t *testing.T) {
 	r := UserRepositoryOnMemory{data: map[string]User{
 		"U06ABGQEB": User{ID: "U06ABGQEB", Name: "dark"},
 	}}
-	f := NewIDReplacerFactory(r)
+	f := NewIDReplacerFactory(r, nil, nil)
 	replacer, e := f.NewReplacer()
 	if assert.NoError(t, e) {
 		actual := replacer.Replace("<@U06ABGQEB>")
-		assert.Equal(t, "@dark", actual)
+		assert.Equal(t, "@\u200ddark", actual)
 	}
 }
+
+type userGroupsOnMemory []UserGroup
+
+func (g userGroupsOnMemory) GetAll() ([]UserGroup, error) {
+	return g, nil
+}
+
+func TestReplaceSpecialMentions(t *testing.T) {
+	replacer := newIDReplacer(nil)
+	assert.Equal(t, "@\u200dhere @\u200dhere @\u200dchannel @\u200deveryone", replacer.Replace("<!here> <!here|@here> <!channel> <!everyone>"))
+}
+
+func TestReplaceUserGroupsAndChannels(t *testing.T) {
+	r := UserRepositoryOnMemory{data: map[string]User{}}
+	g := userGroupsOnMemory{{ID: "S1", Handle: "admins", Name: "Admins"}}
+	c := ChannelRepositoryOnMemory{data: map[string]Channel{"C1": {ID: "C1", Name: "general"}}}
+	replacer, e := NewIDReplacerFactory(r, g, c).NewReplacer()
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, "@\u200dadmins @\u200dold-label @\u200dS3", replacer.Replace("<!subteam^S1|@renamed> <!subteam^S2|@old-label> <!subteam^S3>"))
+	assert.Equal(t, "#general #random #C3", replacer.Replace("<#C1|renamed> <#C2|random> <#C3>"))
+}
+
+type failingUserGroups struct{}
+
+func (g failingUserGroups) GetAll() ([]UserGroup, error) {
+	return nil, errors.New("usergroups.list failed: missing_scope")
+}
+
+func TestReplacerFallsBackToLabelsWithoutUserGroupsAndChannels(t *testing.T) {
+	r := UserRepositoryOnMemory{data: map[string]User{}}
+	logs := bytes.Buffer{}
+	f := NewIDReplacerFactory(r, failingUserGroups{}, failingChannelRepository{})
+	f.Logger = log.New(&logs, "", 0)
+	replacer, e := f.NewReplacer()
+	if !assert.NoError(t, e) {
+		return
+	}
+	assert.Equal(t, "@\u200dadmins @\u200dS2 #general #C2", replacer.Replace("<!subteam^S1|@admins> <!subteam^S2> <#C1|general> <#C2>"))
+	assert.Contains(t, logs.String(), "missing_scope")
+	assert.Contains(t, logs.String(), "failed to get all from channel repository")
+}
+
+func TestReplaceDateWithFallback(t *testing.T) {
+	replacer := newIDReplacer(nil)
+	assert.Equal(t, "at Feb 18th", replacer.Replace("at <!date^1392734382^{date_short}|Feb 18th>"))
+}
+
+func TestReplaceOnlyMentionTokens(t *testing.T) {
+	r := UserRepositoryOnMemory{data: map[string]User{
+		"U1":  User{ID: "U1", Name: "short"},
+		"U12": User{ID: "U12", Name: "long"},
+	}}
+	replacer := newIDReplacer(r)
+	assert.Equal(t, "@\u200dlong @\u200dshort", replacer.Replace("<@U12> <@U1>"))
+	assert.Equal(t, "U1 is not a token, 1 < 2 @\u200dshort", replacer.Replace("U1 is not a token, 1 < 2 <@U1>"))
+	assert.Equal(t, "see <https://example.com|example> @\u200dunknown", replacer.Replace("see <https://example.com|example> <@U9|unknown>"))
+	assert.Equal(t, "unclosed <@U1", replacer.Replace("unclosed <@U1"))
+}
+
+func TestReplaceLooksUpRenamedUser(t *testing.T) {
+	r := UserRepositoryOnMemory{data: map[string]User{
+		"U1": User{ID: "U1", Name: "old"},
+	}}
+	replacer := newIDReplacer(r)
+	assert.Equal(t, "@\u200dold", replacer.Replace("<@U1>"))
+	r.Put(User{ID: "U1", Name: "renamed"})
+	assert.Equal(t, "@\u200drenamed", replacer.Replace("<@U1>"))
+}
@@ -3,16 +3,40 @@ package timeline
 import "fmt"
 
 type Message struct {
-	Text      string
-	UserID    string
-	ChannelID string
-	TimeStamp string
+	Text            string
+	UserID          string
+	ChannelID       string
+	TimeStamp       string
+	ThreadTimeStamp string
+	ParentUserID    string
+	BotID           string
+	// BotName and BotIconURL are how the bot posted the message, which are set only for bots.
+	BotName     string
+	BotIconURL  string
+	Attachments []Attachment
+	Files       []File
+	// TimelineChannelID is the channel the message is posted to.
+	TimelineChannelID string
 }
 
 func (m Message) ToKey() string {
 	return fmt.Sprintf("%s-%s", m.ChannelID, m.TimeStamp)
 }
 
+func (m Message) IsThreadReply() bool {
+	return m.ThreadTimeStamp != "" && m.ThreadTimeStamp != m.TimeStamp
+}
+
+// ThreadParent returns the message which has the key of the parent of the thread.
+func (m Message) ThreadParent() Message {
+	return Message{
+		UserID:            m.ParentUserID,
+		ChannelID:         m.ChannelID,
+		TimeStamp:         m.ThreadTimeStamp,
+		TimelineChannelID: m.TimelineChannelID,
+	}
+}
+
 func NewMessage(text, userID, channelID, timestamp string) Message {
 	return Message{
 		Text:      text,
@@ -12,12 +12,79 @@ func (r MessageRepositoryOnMemory) FindMessageInTimeline(m Message) (*Message, e
 	return nil, nil
 }
 
+func (r MessageRepositoryOnMemory) PostedTimelineChannelIDs(m Message) ([]string, error) {
+	posted, found := r.data[m.ToKey()]
+	if found {
+		return []string{posted.TimelineChannelID}, nil
+	}
+	return []string{}, nil
+}
+
 func (r MessageRepositoryOnMemory) Put(u User, m Message) error {
 	r.data[m.ToKey()] = m
 	return nil
 }
 
+func (r MessageRepositoryOnMemory) Update(u User, m Message) error {
+	_, found := r.data[m.ToKey()]
+	if !found {
+		return MessageNotFoundError{Message: m}
+	}
+	r.data[m.ToKey()] = m
+	return nil
+}
+
 func (r MessageRepositoryOnMemory) Delete(m Message) error {
 	delete(r.data, m.ToKey())
 	return nil
 }
+
+// destinationsRecorder records the timeline channels messages are put to.
+type destinationsRecorder struct {
+	MessageRepositoryOnMemory
+	destinations *[]string
+}
+
+func (r destinationsRecorder) Put(u User, m Message) error {
+	*r.destinations = append(*r.destinations, m.TimelineChannelID)
+	return r.MessageRepositoryOnMemory.Put(u, m)
+}
+
+// userRecorder records the users messages are put as.
+type userRecorder struct {
+	MessageRepositoryOnMemory
+	users *[]User
+}
+
+func (r userRecorder) Put(u User, m Message) error {
+	*r.users = append(*r.users, u)
+	return r.MessageRepositoryOnMemory.Put(u, m)
+}
+
+// failingRepository fails to put each message with its errors in order before it succeeds.
+// The errors are keyed by the timestamps of messages, which are recorded in calls on every put.
+type failingRepository struct {
+	MessageRepositoryOnMemory
+	errors map[string][]error
+	calls  *[]string
+}
+
+func (r failingRepository) Put(u User, m Message) error {
+	*r.calls = append(*r.calls, m.TimeStamp)
+	if es := r.errors[m.TimeStamp]; len(es) > 0 {
+		r.errors[m.TimeStamp] = es[1:]
+		return es[0]
+	}
+	return r.MessageRepositoryOnMemory.Put(u, m)
+}
+
+// kindError is an error of the kind.
+type kindError ErrorKind
+
+func (e kindError) Error() string {
+	return ErrorKind(e).String() + " error"
+}
+
+func (e kindError) Kind() ErrorKind {
+	return ErrorKind(e)
+}
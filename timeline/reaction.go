@@ -0,0 +1,17 @@
+package timeline
+
+// Reaction is an emoji reaction of a user to a message.
+// Message has only the channel and the timestamp of the message.
+type Reaction struct {
+	Name    string
+	UserID  string
+	Message Message
+}
+
+func NewReaction(name, userID, channelID, ts string) Reaction {
+	return Reaction{
+		Name:    name,
+		UserID:  userID,
+		Message: NewMessage("", "", channelID, ts),
+	}
+}
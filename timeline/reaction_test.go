@@ -0,0 +1,116 @@
+package timeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reactionRecorder records the calls to add and remove reactions.
+// counts are the numbers of users who have the reactions on the source message.
+// Adding fails with addError if it is set.
+type reactionRecorder struct {
+	calls    *[]string
+	counts   map[string]int
+	addError *error
+}
+
+func (r reactionRecorder) Add(m Message, name string) error {
+	*r.calls = append(*r.calls, "add "+name+" "+m.TimelineChannelID)
+	if r.addError != nil {
+		return *r.addError
+	}
+	return nil
+}
+
+func (r reactionRecorder) Remove(m Message, name string) error {
+	*r.calls = append(*r.calls, "remove "+name+" "+m.TimelineChannelID)
+	return nil
+}
+
+func (r reactionRecorder) Count(m Message, name string) (int, error) {
+	return r.counts[name], nil
+}
+
+func newReactionServiceForTest(aggregate bool) (TimelineService, reactionRecorder) {
+	posted := NewMessage("hi", "U1", "Csource", "1.0")
+	posted.TimelineChannelID = "Ctimeline"
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{posted.ToKey(): posted}}
+	s := NewServiceForTest(emptyWorker, emptyUserRepository, messageRepository, "Ctimeline", nil)
+	recorder := reactionRecorder{calls: &[]string{}, counts: map[string]int{}}
+	s.ReactionRepository = recorder
+	s.AggregateReactions = aggregate
+	return s, recorder
+}
+
+func TestReactionIsMirroredToTimeline(t *testing.T) {
+	s, recorder := newReactionServiceForTest(false)
+
+	r1 := NewReaction("tada", "U1", "Csource", "1.0")
+	r2 := NewReaction("tada", "U2", "Csource", "1.0")
+	notMirrored := NewReaction("tada", "U1", "Csource", "2.0")
+	recorder.counts["tada"] = 1
+	assert.NoError(t, s.AddReactionInTimeline(&r1))
+	recorder.counts["tada"] = 2
+	assert.NoError(t, s.AddReactionInTimeline(&r2))
+	assert.NoError(t, s.AddReactionInTimeline(&notMirrored))
+	recorder.counts["tada"] = 1
+	assert.NoError(t, s.RemoveReactionInTimeline(&r1))
+	assert.Equal(t, []string{"add tada Ctimeline", "add tada Ctimeline"}, *recorder.calls)
+
+	recorder.counts["tada"] = 0
+	assert.NoError(t, s.RemoveReactionInTimeline(&r2))
+	assert.Equal(t, []string{"add tada Ctimeline", "add tada Ctimeline", "remove tada Ctimeline"}, *recorder.calls)
+}
+
+func TestReactionIsAggregated(t *testing.T) {
+	s, recorder := newReactionServiceForTest(true)
+
+	r1 := NewReaction("tada", "U1", "Csource", "1.0")
+	r2 := NewReaction("tada", "U2", "Csource", "1.0")
+	recorder.counts["tada"] = 1
+	assert.NoError(t, s.AddReactionInTimeline(&r1))
+	recorder.counts["tada"] = 2
+	assert.NoError(t, s.AddReactionInTimeline(&r2))
+	recorder.counts["tada"] = 1
+	assert.NoError(t, s.RemoveReactionInTimeline(&r1))
+	assert.Equal(t, []string{"add tada Ctimeline"}, *recorder.calls)
+
+	recorder.counts["tada"] = 0
+	assert.NoError(t, s.RemoveReactionInTimeline(&r2))
+	assert.Equal(t, []string{"add tada Ctimeline", "remove tada Ctimeline"}, *recorder.calls)
+}
+
+func TestReactionIsCountedOnSlackAfterRestart(t *testing.T) {
+	// another user had reacted before the restart, and the service has never seen it
+	s, recorder := newReactionServiceForTest(true)
+	recorder.counts["tada"] = 1
+
+	r := NewReaction("tada", "U2", "Csource", "1.0")
+	assert.NoError(t, s.RemoveReactionInTimeline(&r))
+	assert.Empty(t, *recorder.calls)
+}
+
+func TestReactionInTimelineIsNotMirrored(t *testing.T) {
+	s, recorder := newReactionServiceForTest(false)
+
+	r := NewReaction("tada", "U1", "Ctimeline", "1.0")
+	assert.NoError(t, s.AddReactionInTimeline(&r))
+	assert.NoError(t, s.RemoveReactionInTimeline(&r))
+	assert.Empty(t, *recorder.calls)
+}
+
+func TestFailedReactionIsAddedOnRetry(t *testing.T) {
+	s, recorder := newReactionServiceForTest(true)
+	var e error = kindError(ErrorRetryable)
+	recorder.addError = &e
+	s.ReactionRepository = recorder
+
+	r1 := NewReaction("tada", "U1", "Csource", "1.0")
+	recorder.counts["tada"] = 1
+	assert.Error(t, s.AddReactionInTimeline(&r1))
+	e = nil
+	assert.NoError(t, s.AddReactionInTimeline(&r1))
+
+	assert.Equal(t, []string{"add tada Ctimeline", "add tada Ctimeline"}, *recorder.calls)
+}
@@ -0,0 +1,137 @@
+package timeline
+
+import (
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// Route maps source channels to the timeline channels their messages are posted to.
+// A source channel matches when its ID is in ChannelIDs or its name matches one of
+// the glob patterns in ChannelNames. A default route matches only the messages
+// which no other route matches.
+type Route struct {
+	ChannelIDs   []string
+	ChannelNames []string
+	Default      bool
+	Timelines    []string
+}
+
+type Router struct {
+	routes            []Route
+	channelRepository ChannelRepository
+}
+
+func NewRouter(routes []Route, channelRepository ChannelRepository) Router {
+	return Router{
+		routes:            routes,
+		channelRepository: channelRepository,
+	}
+}
+
+// Destinations returns the timeline channel IDs the messages from the channel are posted to.
+func (r Router) Destinations(channelID string) ([]string, error) {
+	routes, e := r.matchingRoutes(channelID)
+	if e != nil {
+		return nil, e
+	}
+	dests := []string{}
+	for _, i := range routes {
+		dests = appendUnique(dests, r.routes[i].Timelines...)
+	}
+	return dests, nil
+}
+
+// RouteOf returns the index of the route which posts the messages from the channel to the timeline channel,
+// or -1 if there is none. The first one is returned when several routes do.
+func (r Router) RouteOf(channelID, timelineChannelID string) (int, error) {
+	routes, e := r.matchingRoutes(channelID)
+	if e != nil {
+		return -1, e
+	}
+	for _, i := range routes {
+		if contains(r.routes[i].Timelines, timelineChannelID) {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// matchingRoutes returns the indexes of the routes which match the channel.
+// The default routes are returned only when no other route matches.
+func (r Router) matchingRoutes(channelID string) ([]int, error) {
+	matched := []int{}
+	var name *string
+	for i, route := range r.routes {
+		if route.Default {
+			continue
+		}
+		ok := contains(route.ChannelIDs, channelID)
+		if !ok && len(route.ChannelNames) > 0 {
+			if name == nil {
+				n, e := r.channelName(channelID)
+				if e != nil {
+					return nil, e
+				}
+				name = &n
+			}
+			ok = matchAny(route.ChannelNames, *name)
+		}
+		if ok {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) > 0 {
+		return matched, nil
+	}
+	for i, route := range r.routes {
+		if route.Default {
+			matched = append(matched, i)
+		}
+	}
+	return matched, nil
+}
+
+// TimelineChannelIDs returns all the channel IDs messages can be posted to.
+func (r Router) TimelineChannelIDs() []string {
+	ids := []string{}
+	for _, route := range r.routes {
+		ids = appendUnique(ids, route.Timelines...)
+	}
+	return ids
+}
+
+func (r Router) channelName(channelID string) (string, error) {
+	if r.channelRepository == nil {
+		return "", nil
+	}
+	c, e := r.channelRepository.Get(channelID)
+	if e != nil {
+		return "", errors.Wrap(e, "failed to get channel to route message")
+	}
+	if c == nil {
+		return "", nil
+	}
+	return c.Name, nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(s []string, es ...string) []string {
+	for _, e := range es {
+		if !contains(s, e) {
+			s = append(s, e)
+		}
+	}
+	return s
+}
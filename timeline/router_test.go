@@ -0,0 +1,70 @@
+package timeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var channelsForRouting = ChannelRepositoryOnMemory{data: map[string]Channel{
+	"Ceng1":  Channel{ID: "Ceng1", Name: "eng-backend"},
+	"Ceng2":  Channel{ID: "Ceng2", Name: "eng-frontend"},
+	"Csales": Channel{ID: "Csales", Name: "sales"},
+	"Crand":  Channel{ID: "Crand", Name: "random"},
+}}
+
+var routesForTest = []Route{
+	{ChannelNames: []string{"eng-*"}, Timelines: []string{"Ceng-timeline"}},
+	{ChannelIDs: []string{"Csales", "Ceng2"}, Timelines: []string{"Csales-timeline"}},
+	{Default: true, Timelines: []string{"Ctimeline"}},
+}
+
+func TestRouterDestinations(t *testing.T) {
+	r := NewRouter(routesForTest, channelsForRouting)
+	cases := map[string][]string{
+		"Ceng1":  []string{"Ceng-timeline"},
+		"Ceng2":  []string{"Ceng-timeline", "Csales-timeline"},
+		"Csales": []string{"Csales-timeline"},
+		"Crand":  []string{"Ctimeline"},
+		"Cnone":  []string{"Ctimeline"},
+	}
+	for channelID, expected := range cases {
+		actual, e := r.Destinations(channelID)
+		if assert.NoError(t, e) {
+			assert.Equal(t, expected, actual, channelID)
+		}
+	}
+}
+
+func TestRouterDestinationsWithoutDefault(t *testing.T) {
+	r := NewRouter(routesForTest[:1], channelsForRouting)
+	actual, e := r.Destinations("Crand")
+	if assert.NoError(t, e) {
+		assert.Empty(t, actual)
+	}
+}
+
+func TestRouterTimelineChannelIDs(t *testing.T) {
+	r := NewRouter(routesForTest, channelsForRouting)
+	assert.Equal(t, []string{"Ceng-timeline", "Csales-timeline", "Ctimeline"}, r.TimelineChannelIDs())
+}
+
+func TestRouterRouteOf(t *testing.T) {
+	r := NewRouter(append(routesForTest, Route{ChannelIDs: []string{"Ceng1"}, Timelines: []string{"Csales-timeline", "Ceng-timeline"}}), channelsForRouting)
+	cases := []struct {
+		channelID, timelineChannelID string
+		expected                     int
+	}{
+		{"Ceng1", "Ceng-timeline", 0},
+		{"Ceng1", "Csales-timeline", 3},
+		{"Ceng2", "Csales-timeline", 1},
+		{"Crand", "Ctimeline", 2},
+		{"Crand", "Ceng-timeline", -1},
+	}
+	for _, c := range cases {
+		actual, e := r.RouteOf(c.channelID, c.timelineChannelID)
+		if assert.NoError(t, e) {
+			assert.Equal(t, c.expected, actual, "%s to %s", c.channelID, c.timelineChannelID)
+		}
+	}
+}
@@ -2,40 +2,115 @@ package timeline
 
 import (
 	"log"
+	"time"
 
 	"fmt"
 
 	"github.com/pkg/errors"
 )
 
+// TimelineWorker receives events from Slack and sends them to the channels of events
+// until it fails, when it sends the error to Events.Error.
 type TimelineWorker interface {
-	Polling(
-		messageChan, deletedMessageChan chan *Message,
-		errorChan chan error,
-		endChan chan bool,
-		userCacheClearChan chan interface{},
-	)
+	Polling(events Events)
+}
+
+// Events is the set of channels which a worker sends events to.
+type Events struct {
+	Message         chan *Message
+	Deleted         chan *Message
+	Updated         chan *Message
+	Error           chan error
+	End             chan bool
+	UserCacheClear  chan interface{}
+	UserChanged     chan *User
+	ReactionAdded   chan *Reaction
+	ReactionRemoved chan *Reaction
+}
+
+func NewEvents() Events {
+	return Events{
+		Message:         make(chan *Message),
+		Deleted:         make(chan *Message),
+		Updated:         make(chan *Message),
+		Error:           make(chan error),
+		End:             make(chan bool),
+		UserCacheClear:  make(chan interface{}),
+		UserChanged:     make(chan *User),
+		ReactionAdded:   make(chan *Reaction),
+		ReactionRemoved: make(chan *Reaction),
+	}
 }
 
 type UserRepository interface {
 	Get(userID string) (*User, error)
 	GetAll() ([]User, error)
+	Put(u User) error
 	Clear() error
 }
 
+type ChannelRepository interface {
+	Get(channelID string) (*Channel, error)
+	GetAll() ([]Channel, error)
+}
+
+type UserGroupRepository interface {
+	GetAll() ([]UserGroup, error)
+}
+
+// MessageRepository stores the messages posted to the timeline.
+// Messages are posted to Message.TimelineChannelID and the messages of the same
+// source are stored separately for each timeline channel.
 type MessageRepository interface {
 	FindMessageInTimeline(m Message) (*Message, error)
+	// PostedTimelineChannelIDs returns the timeline channels which m has been posted to.
+	// They are recorded on posting so that they do not change with the routes.
+	PostedTimelineChannelIDs(m Message) ([]string, error)
 	Put(u User, m Message) error
+	Update(u User, m Message) error
+	// Delete deletes the message mirrored from m in the timeline and forgets it.
 	Delete(m Message) error
 }
 
+// ReactionRepository adds and removes the reactions of the bot to the messages in the timeline.
+type ReactionRepository interface {
+	Add(m Message, name string) error
+	Remove(m Message, name string) error
+	// Count returns the number of users who reacted to the source message with the reaction.
+	Count(m Message, name string) (int, error)
+}
+
+// ThreadReplyPolicy decides how thread replies are posted to the timeline.
+type ThreadReplyPolicy string
+
+const (
+	// ThreadReplyInThread posts a reply under the mirrored parent message.
+	// It is posted as a standalone message when the parent was not mirrored.
+	ThreadReplyInThread ThreadReplyPolicy = "thread"
+	// ThreadReplyFlatten posts a reply as a standalone message with "replied to" prefix.
+	ThreadReplyFlatten ThreadReplyPolicy = "flatten"
+)
+
 type TimelineService struct {
 	TimelineWorker    TimelineWorker
 	UserRepository    UserRepository
 	MessageRepository MessageRepository
 	MessageValidator  MessageValidator
-	logger            log.Logger
-	IDReplacer        IDReplacer
+	Router            Router
+	ThreadReplyPolicy ThreadReplyPolicy
+	// ReactionRepository mirrors reactions to the timeline if it is not nil.
+	ReactionRepository ReactionRepository
+	// AggregateReactions makes a reaction mirrored only when the first user reacted,
+	// to reduce the calls of the API.
+	AggregateReactions bool
+	// MaxRetries is how many times an event is handled again on retryable errors.
+	// The event is skipped when all of them failed.
+	MaxRetries int
+	// RetryInterval is the wait before the first retry, which doubles on every retry.
+	RetryInterval time.Duration
+	logger        *log.Logger
+	IDReplacer    IDReplacer
+	retries       *retryQueue
 }
 
 func NewTimelineService(
@@ -43,10 +118,11 @@ func NewTimelineService(
 	userRepository UserRepository,
 	messageRepository MessageRepository,
 	messageValidator MessageValidator,
-	logger log.Logger,
+	router Router,
+	idReplacerFactory IDReplacerFactory,
+	logger *log.Logger,
 ) (TimelineService, error) {
-	f := NewIDReplacerFactory(userRepository)
-	replacer, e := f.NewReplacer()
+	replacer, e := idReplacerFactory.NewReplacer()
 	if e != nil {
 		return TimelineService{}, e
 	}
@@ -55,110 +131,479 @@ func NewTimelineService(
 		UserRepository:    userRepository,
 		MessageRepository: messageRepository,
 		MessageValidator:  messageValidator,
+		Router:            router,
 		logger:            logger,
 		IDReplacer:        replacer,
+		MaxRetries:        defaultMaxRetries,
+		RetryInterval:     defaultRetryInterval,
 	}, nil
 }
 
+var defaultMaxRetries = 3
+
+var defaultRetryInterval = time.Second
+
+// Run handles the events from the worker until it fails or ends.
+// Events are retried while the others are handled, and the retries still waiting
+// are done before Run returns on the end of the worker.
 func (s *TimelineService) Run() error {
-	messageChan := make(chan *Message)
-	deletedMessageChan := make(chan *Message)
-	errorChan := make(chan error)
-	endChan := make(chan bool)
-	userCacheClearChan := make(chan interface{})
-
-	go s.TimelineWorker.Polling(
-		messageChan,
-		deletedMessageChan,
-		errorChan,
-		endChan,
-		userCacheClearChan,
-	)
+	events := NewEvents()
+	s.retries = newRetryQueue()
+	defer s.retries.close()
+	go s.TimelineWorker.Polling(events)
+	ended := false
 	for {
+		if ended && s.retries.pending == 0 {
+			return nil
+		}
+		var e error
 		select {
-		case msg := <-messageChan:
-			e := s.PutToTimeline(msg)
-			if e != nil {
-				return e
-			}
-		case d := <-deletedMessageChan:
-			e := s.DeleteFromTimeline(d)
-			if e != nil {
-				switch e.(type) {
-				case MessageNotFoundError:
-					// do nothing
-				default:
-					return e
-				}
-			}
-		case e := <-errorChan:
+		case msg := <-events.Message:
+			e = s.handle("put "+msg.ToKey(), func() error {
+				// the message is copied as it is rewritten to be posted
+				m := *msg
+				return s.PutToTimeline(&m)
+			})
+		case d := <-events.Deleted:
+			e = s.handle("delete "+d.ToKey(), func() error {
+				return ignoreMessageNotFound(s.DeleteFromTimeline(d))
+			})
+		case u := <-events.Updated:
+			e = s.handle("update "+u.ToKey(), func() error {
+				m := *u
+				return ignoreMessageNotFound(s.UpdateInTimeline(&m))
+			})
+		case e := <-events.Error:
 			return e
-		case _ = <-endChan:
-			return nil
-		case _ = <-userCacheClearChan:
-			e := s.UserRepository.Clear()
-			if e != nil {
-				return e
+		case _ = <-events.End:
+			ended = true
+		case r := <-s.retries.c:
+			s.retries.pending--
+			e = s.try(r)
+		case _ = <-events.UserCacheClear:
+			e = s.handle("clear user cache", s.UserRepository.Clear)
+			if e == nil {
+				s.logger.Printf("User Cache was cleared")
 			}
-			s.logger.Printf("User Cache was cleared")
+		case u := <-events.UserChanged:
+			e = s.handle("update user "+u.ID, func() error {
+				return s.UpdateUser(u)
+			})
+		case r := <-events.ReactionAdded:
+			e = s.handle("add reaction "+r.Name+" to "+r.Message.ToKey(), func() error {
+				return s.AddReactionInTimeline(r)
+			})
+		case r := <-events.ReactionRemoved:
+			e = s.handle("remove reaction "+r.Name+" from "+r.Message.ToKey(), func() error {
+				return s.RemoveReactionInTimeline(r)
+			})
 		default:
 			break
 		}
+		if e != nil {
+			return e
+		}
+	}
+}
+
+// retry is an event which is handled again. interval is the wait before its next retry.
+type retry struct {
+	event    string
+	f        func() error
+	attempts int
+	interval time.Duration
+}
+
+// retryQueue sends the retries to Run after their intervals, so that Run does not wait for them.
+type retryQueue struct {
+	c    chan retry
+	done chan struct{}
+	// pending is the number of the retries which have not been sent yet, which only Run changes.
+	pending int
+}
+
+func newRetryQueue() *retryQueue {
+	return &retryQueue{
+		c:    make(chan retry),
+		done: make(chan struct{}),
+	}
+}
+
+func (q *retryQueue) schedule(r retry, wait time.Duration) {
+	q.pending++
+	time.AfterFunc(wait, func() {
+		select {
+		case q.c <- r:
+		case <-q.done:
+		}
+	})
+}
+
+// close drops the retries which have not been sent yet.
+func (q *retryQueue) close() {
+	close(q.done)
+}
+
+// handle runs f for an event and returns only fatal errors, which stop the service.
+// Retryable errors are tried again up to MaxRetries times, and skippable errors
+// and retryable ones which never succeeded are logged and the event is skipped.
+func (s *TimelineService) handle(event string, f func() error) error {
+	return s.try(retry{event: event, f: f, interval: s.RetryInterval})
+}
+
+func (s *TimelineService) try(r retry) error {
+	e := r.f()
+	if e == nil {
+		return nil
+	}
+	switch KindOf(e) {
+	case ErrorRetryable:
+		if r.attempts < s.MaxRetries {
+			s.logger.Printf("failed to %s. retrying in %s: %+v\n", r.event, r.interval, e)
+			next := r
+			next.attempts++
+			next.interval *= 2
+			s.retries.schedule(next, r.interval)
+			return nil
+		}
+		s.logger.Printf("skipped to %s after %d retries: %+v\n", r.event, s.MaxRetries, e)
+		return nil
+	case ErrorSkippable:
+		s.logger.Printf("skipped to %s: %+v\n", r.event, e)
+		return nil
+	default:
+		return errors.Wrap(e, "failed to "+r.event)
+	}
+}
+
+// ignoreMessageNotFound ignores the error of messages which were not posted to the timeline.
+func ignoreMessageNotFound(e error) error {
+	if _, ok := e.(MessageNotFoundError); ok {
+		return nil
+	}
+	return e
+}
+
+// UpdateUser refreshes the user in the cache when the user was changed or joined,
+// and then the mentions of the user are replaced with the current name.
+func (service *TimelineService) UpdateUser(u *User) error {
+	e := service.UserRepository.Put(*u)
+	if e != nil {
+		return errors.Wrap(e, "failed to update user")
 	}
 	return nil
 }
 
 func (service *TimelineService) PutToTimeline(m *Message) error {
-	if !service.MessageValidator.IsTargetMessage(m) {
+	if !service.isTargetMessage(m) {
 		return nil
 	}
-	u, e := service.UserRepository.Get(m.UserID)
+	dests, e := service.Router.Destinations(m.ChannelID)
 	if e != nil {
 		return e
 	}
-	if u == nil {
-		return errors.New(fmt.Sprintf("user not found. id: %s", m.UserID))
+	if len(dests) == 0 {
+		return nil
 	}
-	t := service.IDReplacer.Replace(m.Text)
-	m.Text = t
-
-	e = service.MessageRepository.Put(*u, *m)
+	u, e := service.author(m)
 	if e != nil {
 		return e
 	}
+	service.flattenThreadReply(m)
+
+	for _, d := range dests {
+		posted := *m
+		posted.TimelineChannelID = d
+		e = service.MessageRepository.Put(*u, posted)
+		if e != nil {
+			return e
+		}
+	}
 	return nil
 }
 
-func (service *TimelineService) DeleteFromTimeline(originMessage *Message) error {
-	m, e := service.MessageRepository.FindMessageInTimeline(*originMessage)
+func (service *TimelineService) UpdateInTimeline(m *Message) error {
+	if !service.isTargetMessage(m) {
+		return nil
+	}
+	dests, e := service.MessageRepository.PostedTimelineChannelIDs(*m)
 	if e != nil {
 		return e
+	}
+	if len(dests) == 0 {
+		return MessageNotFoundError{
+			Message: *m,
+		}
+	}
+	u, e := service.author(m)
+	if e != nil {
+		return e
+	}
+	service.flattenThreadReply(m)
 
+	found := false
+	for _, d := range dests {
+		updated := *m
+		updated.TimelineChannelID = d
+		e = service.MessageRepository.Update(*u, updated)
+		if _, ok := e.(MessageNotFoundError); ok {
+			continue
+		}
+		if e != nil {
+			return e
+		}
+		found = true
 	}
-	if m == nil {
+	if !found {
 		return MessageNotFoundError{
-			Message: *originMessage,
+			Message: *m,
 		}
 	}
-	e = service.MessageRepository.Delete(*m)
+	return nil
+}
+
+// isTargetMessage checks the source of the message, and then rewrites the mentions in the text
+// and the attachments so that the filters see the text which is posted.
+func (service *TimelineService) isTargetMessage(m *Message) bool {
+	if !service.MessageValidator.IsTargetSource(m) {
+		return false
+	}
+	m.Text = service.IDReplacer.Replace(m.Text)
+	m.Attachments = service.replaceAttachments(m.Attachments)
+	if !service.MessageValidator.Accept(m) {
+		return false
+	}
+	service.MessageValidator.Trim(m)
+	return true
+}
+
+// replaceAttachments returns the copies of the attachments whose mentions are rewritten.
+func (service *TimelineService) replaceAttachments(as []Attachment) []Attachment {
+	if len(as) == 0 {
+		return as
+	}
+	replaced := make([]Attachment, 0, len(as))
+	for _, a := range as {
+		a.Pretext = service.IDReplacer.Replace(a.Pretext)
+		a.Title = service.IDReplacer.Replace(a.Title)
+		a.Text = service.IDReplacer.Replace(a.Text)
+		a.Fallback = service.IDReplacer.Replace(a.Fallback)
+		replaced = append(replaced, a)
+	}
+	return replaced
+}
+
+// author returns the user who posted the message.
+// Messages of bots without users are posted with the name and the icon of the bot.
+func (service *TimelineService) author(m *Message) (*User, error) {
+	if m.UserID == "" && m.BotID != "" {
+		name := m.BotName
+		if name == "" {
+			name = m.BotID
+		}
+		return &User{ID: m.BotID, Name: name, ProfileImageURL: m.BotIconURL}, nil
+	}
+	u, e := service.UserRepository.Get(m.UserID)
+	if e != nil {
+		return nil, e
+	}
+	if u == nil {
+		return nil, UserNotFoundError{UserID: m.UserID}
+	}
+	return u, nil
+}
+
+func (service *TimelineService) flattenThreadReply(m *Message) {
+	if service.ThreadReplyPolicy != ThreadReplyFlatten || !m.IsThreadReply() {
+		return
+	}
+	to := "a thread"
+	if p, e := service.UserRepository.Get(m.ParentUserID); e == nil && p != nil && p.Name != "" {
+		to = mentionMark + p.Name
+	}
+	m.Text = fmt.Sprintf("replied to %s: %s", to, m.Text)
+	m.ThreadTimeStamp = ""
+}
+
+// AddReactionInTimeline adds the reaction to the messages mirrored from the reacted message.
+func (service *TimelineService) AddReactionInTimeline(r *Reaction) error {
+	if service.ReactionRepository == nil || service.isInTimeline(r) {
+		return nil
+	}
+	if service.AggregateReactions {
+		// the reaction was mirrored when the first user reacted
+		n, e := service.ReactionRepository.Count(r.Message, r.Name)
+		if e != nil {
+			return e
+		}
+		if n > 1 {
+			return nil
+		}
+	}
+	return service.reactInTimeline(r, service.ReactionRepository.Add)
+}
+
+// RemoveReactionInTimeline removes the reaction from the messages mirrored from the reacted message
+// when no user has the reaction on it any longer.
+// The users are counted on Slack so that the count is right after restarts.
+func (service *TimelineService) RemoveReactionInTimeline(r *Reaction) error {
+	if service.ReactionRepository == nil || service.isInTimeline(r) {
+		return nil
+	}
+	n, e := service.ReactionRepository.Count(r.Message, r.Name)
 	if e != nil {
-		e = errors.Wrap(e, "failed to delete message in timeline")
 		return e
 	}
+	if n > 0 {
+		return nil
+	}
+	return service.reactInTimeline(r, service.ReactionRepository.Remove)
+}
+
+// isInTimeline returns whether the reaction is to a message in the timeline.
+// They are not mirrored including the bot's own ones.
+func (service *TimelineService) isInTimeline(r *Reaction) bool {
+	return contains(service.MessageValidator.TimelineChannelIDs, r.Message.ChannelID)
+}
+
+func (service *TimelineService) reactInTimeline(r *Reaction, react func(m Message, name string) error) error {
+	dests, e := service.MessageRepository.PostedTimelineChannelIDs(r.Message)
+	if e != nil {
+		return e
+	}
+	for _, d := range dests {
+		origin := r.Message
+		origin.TimelineChannelID = d
+		m, e := service.MessageRepository.FindMessageInTimeline(origin)
+		if e != nil {
+			return e
+		}
+		if m == nil {
+			continue
+		}
+		e = react(*m, r.Name)
+		if e != nil {
+			return errors.Wrap(e, fmt.Sprintf("failed to mirror reaction %s to %s", r.Name, m.ToKey()))
+		}
+	}
 	return nil
 }
 
+func (service *TimelineService) DeleteFromTimeline(originMessage *Message) error {
+	dests, e := service.MessageRepository.PostedTimelineChannelIDs(*originMessage)
+	if e != nil {
+		return e
+	}
+	found := false
+	for _, d := range dests {
+		origin := *originMessage
+		origin.TimelineChannelID = d
+		m, e := service.MessageRepository.FindMessageInTimeline(origin)
+		if e != nil {
+			return e
+		}
+		if m == nil {
+			continue
+		}
+		found = true
+		e = service.MessageRepository.Delete(origin)
+		if e != nil {
+			e = errors.Wrap(e, "failed to delete message in timeline")
+			return e
+		}
+	}
+	if !found {
+		return MessageNotFoundError{
+			Message: *originMessage,
+		}
+	}
+	return nil
+}
+
+// MessageValidator decides which messages are posted to the timeline.
+// When WhiteListChannelIDs or WhiteListChannelNames is set, only the messages from
+// the channels in them are posted, and then BlackListChannelIDs is applied to them.
+// The messages from the target channels are checked by Filters in order.
+// The messages posted by the timeline itself, which are from OwnUserID or OwnBotID,
+// are never posted again so that they do not loop.
 type MessageValidator struct {
-	TimelineChannelID   string
-	BlackListChannelIDs []string
+	TimelineChannelIDs    []string
+	BlackListChannelIDs   []string
+	WhiteListChannelIDs   []string
+	WhiteListChannelNames []string
+	ChannelRepository     ChannelRepository
+	OwnUserID             string
+	OwnBotID              string
+	Filters               []MessageFilter
+	// DebugLogger logs why messages were rejected by Filters if it is not nil.
+	DebugLogger *log.Logger
+	// Logger logs the errors while checking messages if it is not nil.
+	Logger *log.Logger
 }
 
 func (v MessageValidator) IsTargetMessage(m *Message) bool {
-	return m.ChannelID != v.TimelineChannelID &&
+	return v.IsTargetSource(m) && v.Accept(m)
+}
+
+// IsTargetSource checks the channel and the poster of the message.
+func (v MessageValidator) IsTargetSource(m *Message) bool {
+	return !contains(v.TimelineChannelIDs, m.ChannelID) &&
+		!v.isOwnMessage(m) &&
 		isPublic(m.ChannelID) &&
+		v.isWhiteListed(m.ChannelID) &&
 		!contains(v.BlackListChannelIDs, m.ChannelID)
 }
 
+// Accept checks the content of the message by Filters.
+func (v MessageValidator) Accept(m *Message) bool {
+	for _, f := range v.Filters {
+		if reason := f.Reject(m); reason != "" {
+			if v.DebugLogger != nil {
+				v.DebugLogger.Printf("[debug] rejected %s: %s\n", m.ToKey(), reason)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Trim removes the parts of the accepted message which Filters do not post.
+func (v MessageValidator) Trim(m *Message) {
+	for _, f := range v.Filters {
+		if t, ok := f.(MessageTrimmer); ok {
+			t.Trim(m)
+		}
+	}
+}
+
+func (v MessageValidator) isOwnMessage(m *Message) bool {
+	return (v.OwnUserID != "" && m.UserID == v.OwnUserID) ||
+		(v.OwnBotID != "" && m.BotID == v.OwnBotID)
+}
+
+func (v MessageValidator) isWhiteListed(channelID string) bool {
+	if len(v.WhiteListChannelIDs) == 0 && len(v.WhiteListChannelNames) == 0 {
+		return true
+	}
+	if contains(v.WhiteListChannelIDs, channelID) {
+		return true
+	}
+	if len(v.WhiteListChannelNames) == 0 || v.ChannelRepository == nil {
+		return false
+	}
+	c, e := v.ChannelRepository.Get(channelID)
+	if e != nil {
+		if v.Logger != nil {
+			v.Logger.Printf("failed to get channel %s to check white list: %+v\n", channelID, e)
+		}
+		return false
+	}
+	if c == nil {
+		return false
+	}
+	return matchAny(v.WhiteListChannelNames, c.Name)
+}
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -179,3 +624,17 @@ type MessageNotFoundError struct {
 func (e MessageNotFoundError) Error() string {
 	return fmt.Sprintf("key of %s is not found\n", e.Message.ToKey())
 }
+
+// UserNotFoundError is returned when the author of a message is not found.
+// It is skippable since only the message cannot be posted.
+type UserNotFoundError struct {
+	UserID string
+}
+
+func (e UserNotFoundError) Error() string {
+	return fmt.Sprintf("user not found. id: %s", e.UserID)
+}
+
+func (e UserNotFoundError) Kind() ErrorKind {
+	return ErrorSkippable
+}
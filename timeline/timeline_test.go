@@ -1,21 +1,19 @@
 package timeline
 
 import (
+	"bytes"
 	"log"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
 var emptyWorker = TimelineWorkerMock{
-	polling: func(
-		messageChan, deletedMessageChan chan *Message,
-		errorChan chan error,
-		endChan chan bool,
-		userCacheClearChan chan interface{},
-	) {
-		endChan <- true
+	polling: func(events Events) {
+		events.End <- true
 	},
 }
 
@@ -32,10 +30,11 @@ func NewServiceForTest(
 ) TimelineService {
 	logger := log.New(os.Stdout, "", log.Ldate+log.Ltime+log.Lshortfile)
 	v := MessageValidator{
-		TimelineChannelID:   t,
+		TimelineChannelIDs:  []string{t},
 		BlackListChannelIDs: bs,
 	}
-	r, _ := NewTimelineService(worker, userRepository, messageRepository, v, *logger)
+	router := NewRouter([]Route{{Default: true, Timelines: []string{t}}}, nil)
+	r, _ := NewTimelineService(worker, userRepository, messageRepository, v, router, NewIDReplacerFactory(userRepository, nil, nil), logger)
 	return r
 }
 
@@ -72,6 +71,76 @@ func TestIsTargetReturnTrue(t *testing.T) {
 
 }
 
+func TestIsTargetWithWhiteList(t *testing.T) {
+	v := MessageValidator{
+		TimelineChannelIDs:    []string{"Ctimeline"},
+		BlackListChannelIDs:   []string{"Ceng2", "Crand"},
+		WhiteListChannelIDs:   []string{"Csales", "Crand"},
+		WhiteListChannelNames: []string{"eng-*"},
+		ChannelRepository:     channelsForRouting,
+	}
+	cases := map[string]bool{
+		// white listed by ID
+		"Csales": true,
+		// white listed by name
+		"Ceng1": true,
+		// white listed by name but black listed
+		"Ceng2": false,
+		// white listed by ID but black listed
+		"Crand": false,
+		// not white listed
+		"Cother": false,
+		// timeline is never a target
+		"Ctimeline": false,
+	}
+	for channelID, expected := range cases {
+		m := Message{ChannelID: channelID}
+		assert.Equal(t, expected, v.IsTargetMessage(&m), channelID)
+	}
+}
+
+// failingChannelRepository fails to get channels.
+type failingChannelRepository struct {
+	ChannelRepositoryOnMemory
+}
+
+func (r failingChannelRepository) Get(channelID string) (*Channel, error) {
+	return nil, errors.New("channels.info failed")
+}
+
+func (r failingChannelRepository) GetAll() ([]Channel, error) {
+	return nil, errors.New("conversations.list failed")
+}
+
+func TestIsTargetLogsErrorOfWhiteList(t *testing.T) {
+	b := &bytes.Buffer{}
+	v := MessageValidator{
+		WhiteListChannelNames: []string{"eng-*"},
+		ChannelRepository:     failingChannelRepository{},
+		Logger:                log.New(b, "", 0),
+	}
+	assert.False(t, v.IsTargetMessage(&Message{ChannelID: "Ceng1"}))
+	assert.Contains(t, b.String(), "channels.info failed")
+}
+
+func TestIsTargetWithWhiteListOfIDsOnly(t *testing.T) {
+	v := MessageValidator{
+		TimelineChannelIDs:  []string{"Ctimeline"},
+		WhiteListChannelIDs: []string{"Csales"},
+	}
+	assert.True(t, v.IsTargetMessage(&Message{ChannelID: "Csales"}))
+	assert.False(t, v.IsTargetMessage(&Message{ChannelID: "Ceng1"}))
+}
+
+func TestIsTargetWithoutWhiteList(t *testing.T) {
+	v := MessageValidator{
+		TimelineChannelIDs:  []string{"Ctimeline"},
+		BlackListChannelIDs: []string{"Crand"},
+	}
+	assert.True(t, v.IsTargetMessage(&Message{ChannelID: "Csales"}))
+	assert.False(t, v.IsTargetMessage(&Message{ChannelID: "Crand"}))
+}
+
 func TestTimelineServicePutMessage(t *testing.T) {
 	userRepository := UserRepositoryOnMemory{data: map[string]User{
 		"userid": User{},
@@ -91,6 +160,65 @@ func TestTimelineServicePutMessage(t *testing.T) {
 	}
 }
 
+func TestTimelineServicePutThreadReply(t *testing.T) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"userid":   User{ID: "userid", Name: "replier"},
+		"parentid": User{ID: "parentid", Name: "parent"},
+	}}
+	reply := Message{
+		Text:            "hogefuga",
+		UserID:          "userid",
+		ChannelID:       "Cchannel",
+		TimeStamp:       "ts2",
+		ThreadTimeStamp: "ts1",
+		ParentUserID:    "parentid",
+	}
+	cases := []struct {
+		policy   ThreadReplyPolicy
+		text     string
+		threadTS string
+	}{
+		{ThreadReplyInThread, "hogefuga", "ts1"},
+		{ThreadReplyFlatten, "replied to @\u200dparent: hogefuga", ""},
+	}
+	for _, c := range cases {
+		messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+		s := NewServiceForTest(emptyWorker, userRepository, messageRepository, "timelineChannelID", nil)
+		s.ThreadReplyPolicy = c.policy
+		m := reply
+		e := s.PutToTimeline(&m)
+		if assert.NoError(t, e) {
+			actual := messageRepository.data[m.ToKey()]
+			assert.Equal(t, c.text, actual.Text)
+			assert.Equal(t, c.threadTS, actual.ThreadTimeStamp)
+		}
+	}
+}
+
+func TestTimelineServicePutMessageToEveryDestination(t *testing.T) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"userid": User{},
+	}}
+	messageRepository := destinationsRecorder{
+		MessageRepositoryOnMemory: MessageRepositoryOnMemory{data: map[string]Message{}},
+		destinations:              &[]string{},
+	}
+	logger := log.New(os.Stdout, "", log.Ldate+log.Ltime+log.Lshortfile)
+	router := NewRouter(routesForTest, channelsForRouting)
+	v := MessageValidator{TimelineChannelIDs: router.TimelineChannelIDs()}
+	s, _ := NewTimelineService(emptyWorker, userRepository, messageRepository, v, router, NewIDReplacerFactory(userRepository, nil, nil), logger)
+	m := Message{
+		Text:      "hogefuga",
+		UserID:    "userid",
+		ChannelID: "Ceng2",
+		TimeStamp: "ts",
+	}
+	e := s.PutToTimeline(&m)
+	if assert.NoError(t, e) {
+		assert.Equal(t, []string{"Ceng-timeline", "Csales-timeline"}, *messageRepository.destinations)
+	}
+}
+
 func TestTimelineServiceDeleteFromTimeline(t *testing.T) {
 	userRepository := UserRepositoryOnMemory{data: map[string]User{
 		"userid": User{},
@@ -112,6 +240,46 @@ func TestTimelineServiceDeleteFromTimeline(t *testing.T) {
 	}
 }
 
+func TestTimelineServiceUpdateInTimeline(t *testing.T) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"userid": User{},
+	}}
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+	s := NewServiceForTest(emptyWorker, userRepository, messageRepository, "timelineChannelID", nil)
+	m := Message{
+		Text:      "hogefuga",
+		UserID:    "userid",
+		ChannelID: "Cchannel",
+		TimeStamp: "ts",
+	}
+	s.PutToTimeline(&m)
+	edited := m
+	edited.Text = "piyo"
+	e := s.UpdateInTimeline(&edited)
+
+	if assert.NoError(t, e) {
+		actual, found := messageRepository.data[m.ToKey()]
+		assert.True(t, found)
+		assert.Equal(t, "piyo", actual.Text)
+	}
+}
+
+func TestTimelineServiceUpdateInTimelineReturnsNotFound(t *testing.T) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"userid": User{},
+	}}
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+	s := NewServiceForTest(emptyWorker, userRepository, messageRepository, "timelineChannelID", nil)
+	m := Message{
+		Text:      "hogefuga",
+		UserID:    "userid",
+		ChannelID: "Cchannel",
+		TimeStamp: "ts",
+	}
+	e := s.UpdateInTimeline(&m)
+	assert.IsType(t, MessageNotFoundError{}, e)
+}
+
 func TestTimelineServicePutMessageFromWorker(t *testing.T) {
 	userRepository := UserRepositoryOnMemory{data: map[string]User{
 		"userid": User{},
@@ -124,14 +292,9 @@ func TestTimelineServicePutMessageFromWorker(t *testing.T) {
 		ChannelID: "Cchannel",
 		TimeStamp: "ts",
 	}
-	polling := func(
-		messageChan, deletedMessageChan chan *Message,
-		errorChan chan error,
-		endChan chan bool,
-		userCacheClearChan chan interface{},
-	) {
-		messageChan <- &m
-		endChan <- true
+	polling := func(events Events) {
+		events.Message <- &m
+		events.End <- true
 	}
 	worker := TimelineWorkerMock{polling: polling}
 	s := NewServiceForTest(worker, userRepository, messageRepository, "timelineChannelID", nil)
@@ -141,6 +304,33 @@ func TestTimelineServicePutMessageFromWorker(t *testing.T) {
 	assert.Equal(t, m.Text, actual.Text)
 }
 
+func TestTimelineServiceUpdateUserFromWorker(t *testing.T) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"userid": User{ID: "userid", Name: "old"},
+	}}
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+
+	m := Message{
+		Text:      "hi <@userid> and <@newcomer>",
+		UserID:    "userid",
+		ChannelID: "Cchannel",
+		TimeStamp: "ts",
+	}
+	polling := func(events Events) {
+		events.UserChanged <- &User{ID: "userid", Name: "renamed"}
+		events.UserChanged <- &User{ID: "newcomer", Name: "newcomer"}
+		events.Message <- &m
+		events.End <- true
+	}
+	worker := TimelineWorkerMock{polling: polling}
+	s := NewServiceForTest(worker, userRepository, messageRepository, "timelineChannelID", nil)
+	e := s.Run()
+	if assert.NoError(t, e) {
+		assert.Equal(t, "renamed", userRepository.data["userid"].Name)
+		assert.Equal(t, "hi @\u200drenamed and @\u200dnewcomer", messageRepository.data[m.ToKey()].Text)
+	}
+}
+
 func TestTimelineServiceDeleteFromTimelineFromWorker(t *testing.T) {
 	userRepository := UserRepositoryOnMemory{data: map[string]User{
 		"userid": User{},
@@ -153,15 +343,10 @@ func TestTimelineServiceDeleteFromTimelineFromWorker(t *testing.T) {
 		TimeStamp: "ts",
 		UserID:    "userid",
 	}
-	polling := func(
-		messageChan, deletedMessageChan chan *Message,
-		errorChan chan error,
-		endChan chan bool,
-		userCacheClearChan chan interface{},
-	) {
-		messageChan <- &m
-		deletedMessageChan <- &m
-		endChan <- true
+	polling := func(events Events) {
+		events.Message <- &m
+		events.Deleted <- &m
+		events.End <- true
 	}
 	worker := TimelineWorkerMock{polling: polling}
 	s := NewServiceForTest(worker, userRepository, messageRepository, "timelineChannelID", nil)
@@ -171,3 +356,147 @@ func TestTimelineServiceDeleteFromTimelineFromWorker(t *testing.T) {
 		assert.False(t, found)
 	}
 }
+
+func TestPutBotMessageToTimelineAsBot(t *testing.T) {
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+	users := []User{}
+	s := NewServiceForTest(emptyWorker, emptyUserRepository, userRecorder{messageRepository, &users}, "timelineChannelID", nil)
+	m := Message{
+		Text:       "deployed",
+		ChannelID:  "Cdeploy",
+		TimeStamp:  "ts",
+		BotID:      "BCI",
+		BotName:    "CI",
+		BotIconURL: "https://example.com/ci.png",
+	}
+	if assert.NoError(t, s.PutToTimeline(&m)) && assert.Len(t, users, 1) {
+		assert.Equal(t, User{ID: "BCI", Name: "CI", ProfileImageURL: "https://example.com/ci.png"}, users[0])
+	}
+}
+
+func TestPutToTimelineRewritesMentionsInAttachments(t *testing.T) {
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+	s := NewServiceForTest(emptyWorker, emptyUserRepository, messageRepository, "timelineChannelID", nil)
+	attachments := []Attachment{{
+		Pretext:  "<!channel> deployed",
+		Title:    "<!here>",
+		Text:     "by <@U1|dark>",
+		Fallback: "<!subteam^S1|@admins>",
+	}}
+	m := Message{ChannelID: "Cdeploy", TimeStamp: "ts", BotID: "BCI", Attachments: attachments}
+	if assert.NoError(t, s.PutToTimeline(&m)) {
+		a := messageRepository.data[m.ToKey()].Attachments[0]
+		assert.Equal(t, "@\u200dchannel deployed", a.Pretext)
+		assert.Equal(t, "@\u200dhere", a.Title)
+		assert.Equal(t, "by @\u200ddark", a.Text)
+		assert.Equal(t, "@\u200dadmins", a.Fallback)
+	}
+	assert.Equal(t, "<!channel> deployed", attachments[0].Pretext)
+}
+
+// runWithFailingRepository runs the service for two messages, the first of which fails with errs.
+func runWithFailingRepository(t *testing.T, errs []error, interval time.Duration) (MessageRepositoryOnMemory, []string, error) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"userid": User{},
+	}}
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+	calls := []string{}
+	repository := failingRepository{messageRepository, map[string][]error{"1": errs}, &calls}
+	polling := func(events Events) {
+		events.Message <- &Message{Text: "first", UserID: "userid", ChannelID: "Cchannel", TimeStamp: "1"}
+		events.Message <- &Message{Text: "second", UserID: "userid", ChannelID: "Cchannel", TimeStamp: "2"}
+		events.End <- true
+	}
+	s := NewServiceForTest(TimelineWorkerMock{polling: polling}, userRepository, repository, "timelineChannelID", nil)
+	s.RetryInterval = interval
+	e := s.Run()
+	return messageRepository, calls, e
+}
+
+func TestRunRetriesRetryableErrors(t *testing.T) {
+	r, calls, e := runWithFailingRepository(t, []error{kindError(ErrorRetryable), kindError(ErrorRetryable)}, 0)
+	if assert.NoError(t, e) {
+		assert.Len(t, calls, 4)
+		assert.Len(t, r.data, 2)
+	}
+}
+
+func TestRunHandlesOtherEventsWhileWaitingForRetry(t *testing.T) {
+	r, calls, e := runWithFailingRepository(t, []error{kindError(ErrorRetryable)}, 50*time.Millisecond)
+	if assert.NoError(t, e) {
+		assert.Equal(t, []string{"1", "2", "1"}, calls)
+		assert.Len(t, r.data, 2)
+	}
+}
+
+func TestRunSkipsRetryableErrorsAfterRetries(t *testing.T) {
+	errs := []error{}
+	for i := 0; i <= defaultMaxRetries; i++ {
+		errs = append(errs, kindError(ErrorRetryable))
+	}
+	r, calls, e := runWithFailingRepository(t, errs, 0)
+	if assert.NoError(t, e) {
+		assert.Len(t, calls, defaultMaxRetries+2)
+		assert.Len(t, r.data, 1)
+	}
+}
+
+func TestRunSkipsSkippableErrors(t *testing.T) {
+	r, calls, e := runWithFailingRepository(t, []error{kindError(ErrorSkippable)}, 0)
+	if assert.NoError(t, e) {
+		assert.Len(t, calls, 2)
+		assert.Len(t, r.data, 1)
+	}
+}
+
+func TestRunStopsOnFatalErrors(t *testing.T) {
+	r, calls, e := runWithFailingRepository(t, []error{errors.New("broken")}, 0)
+	if assert.Error(t, e) {
+		assert.Equal(t, []string{"1"}, calls)
+		assert.Empty(t, r.data)
+	}
+}
+
+func TestRunSkipsMessagesOfUnknownUsers(t *testing.T) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"userid": User{},
+	}}
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+	polling := func(events Events) {
+		events.Message <- &Message{Text: "first", UserID: "unknown", ChannelID: "Cchannel", TimeStamp: "1"}
+		events.Message <- &Message{Text: "second", UserID: "userid", ChannelID: "Cchannel", TimeStamp: "2"}
+		events.End <- true
+	}
+	s := NewServiceForTest(TimelineWorkerMock{polling: polling}, userRepository, messageRepository, "timelineChannelID", nil)
+	if assert.NoError(t, s.Run()) {
+		assert.Len(t, messageRepository.data, 1)
+	}
+}
+
+func TestTimelineServiceUpdatesAndDeletesWhereMessageWasPosted(t *testing.T) {
+	userRepository := UserRepositoryOnMemory{data: map[string]User{
+		"userid": User{},
+	}}
+	messageRepository := MessageRepositoryOnMemory{data: map[string]Message{}}
+	s := NewServiceForTest(emptyWorker, userRepository, messageRepository, "Cold", nil)
+	m := Message{
+		Text:      "hogefuga",
+		UserID:    "userid",
+		ChannelID: "Cchannel",
+		TimeStamp: "ts",
+	}
+	assert.NoError(t, s.PutToTimeline(&m))
+	s.Router = NewRouter([]Route{{Default: true, Timelines: []string{"Cnew"}}}, nil)
+
+	edited := m
+	edited.Text = "piyo"
+	if assert.NoError(t, s.UpdateInTimeline(&edited)) {
+		actual := messageRepository.data[m.ToKey()]
+		assert.Equal(t, "Cold", actual.TimelineChannelID)
+		assert.Equal(t, "piyo", actual.Text)
+	}
+	if assert.NoError(t, s.DeleteFromTimeline(&m)) {
+		_, found := messageRepository.data[m.ToKey()]
+		assert.False(t, found)
+	}
+}
@@ -1,9 +1,22 @@
 package timeline
 
 type User struct {
-	ID              string
-	Name            string
-	ProfileImageURL string
+	ID               string
+	Name             string
+	DisplayName      string
+	RealName         string
+	ProfileImageURL  string
+	ProfileImageURLs ProfileImageURLs
+}
+
+// ProfileImageURLs are the URLs of the avatar in each size.
+// ProfileImageURL of User is the one of 48px.
+type ProfileImageURLs struct {
+	Size24  string
+	Size32  string
+	Size72  string
+	Size192 string
+	Size512 string
 }
 
 func NewUser(id, name, profileImageURL string) User {
@@ -13,3 +26,78 @@ func NewUser(id, name, profileImageURL string) User {
 		ProfileImageURL: profileImageURL,
 	}
 }
+
+// NameField is which name of users is shown in the timeline.
+type NameField string
+
+const (
+	NameFieldName        NameField = "name"
+	NameFieldDisplayName NameField = "display_name"
+	NameFieldRealName    NameField = "real_name"
+)
+
+// NameBy returns the name in the field.
+// If it is empty, display name, real name and name are used in this order.
+func (u User) NameBy(f NameField) string {
+	names := map[NameField]string{
+		NameFieldName:        u.Name,
+		NameFieldDisplayName: u.DisplayName,
+		NameFieldRealName:    u.RealName,
+	}
+	if n := names[f]; n != "" {
+		return n
+	}
+	for _, n := range []string{u.DisplayName, u.RealName, u.Name} {
+		if n != "" {
+			return n
+		}
+	}
+	return ""
+}
+
+// WithNameField returns the repository whose users have the name in the field as Name.
+func WithNameField(r UserRepository, f NameField) UserRepository {
+	return userRepositoryWithNameField{
+		UserRepository: r,
+		field:          f,
+	}
+}
+
+type userRepositoryWithNameField struct {
+	UserRepository
+	field NameField
+}
+
+func (r userRepositoryWithNameField) Get(userID string) (*User, error) {
+	u, e := r.UserRepository.Get(userID)
+	if e != nil || u == nil {
+		return u, e
+	}
+	u.Name = u.NameBy(r.field)
+	return u, nil
+}
+
+func (r userRepositoryWithNameField) GetAll() ([]User, error) {
+	us, e := r.UserRepository.GetAll()
+	if e != nil {
+		return nil, e
+	}
+	for i := range us {
+		us[i].Name = us[i].NameBy(r.field)
+	}
+	return us, nil
+}
+
+type UserGroup struct {
+	ID     string
+	Handle string
+	Name   string
+}
+
+func NewUserGroup(id, handle, name string) UserGroup {
+	return UserGroup{
+		ID:     id,
+		Handle: handle,
+		Name:   name,
+	}
+}
@@ -20,6 +20,11 @@ func (r UserRepositoryOnMemory) GetAll() ([]User, error) {
 	return vs, nil
 }
 
+func (r UserRepositoryOnMemory) Put(u User) error {
+	r.data[u.ID] = u
+	return nil
+}
+
 func (r UserRepositoryOnMemory) Clear() error {
 	r.data = map[string]User{}
 	return nil
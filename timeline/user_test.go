@@ -0,0 +1,35 @@
+package timeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserNameBy(t *testing.T) {
+	u := User{Name: "dark", DisplayName: "Dark", RealName: "Dark Knight"}
+	assert.Equal(t, "dark", u.NameBy(NameFieldName))
+	assert.Equal(t, "Dark", u.NameBy(NameFieldDisplayName))
+	assert.Equal(t, "Dark Knight", u.NameBy(NameFieldRealName))
+}
+
+func TestUserNameByFallsBack(t *testing.T) {
+	assert.Equal(t, "Dark Knight", User{Name: "dark", RealName: "Dark Knight"}.NameBy(NameFieldDisplayName))
+	assert.Equal(t, "dark", User{Name: "dark"}.NameBy(NameFieldRealName))
+	assert.Equal(t, "Dark", User{DisplayName: "Dark"}.NameBy(NameFieldName))
+}
+
+func TestReplaceUserIDToDisplayName(t *testing.T) {
+	r := UserRepositoryOnMemory{data: map[string]User{
+		"U06ABGQEB": User{ID: "U06ABGQEB", Name: "dark", DisplayName: "Dark"},
+	}}
+	f := NewIDReplacerFactory(WithNameField(r, NameFieldDisplayName), nil, nil)
+	replacer, e := f.NewReplacer()
+	if assert.NoError(t, e) {
+		assert.Equal(t, "@\u200dDark", replacer.Replace("<@U06ABGQEB>"))
+	}
+	u, e := WithNameField(r, NameFieldDisplayName).Get("U06ABGQEB")
+	if assert.NoError(t, e) {
+		assert.Equal(t, "Dark", u.Name)
+	}
+}
@@ -1,19 +1,9 @@
 package timeline
 
 type TimelineWorkerMock struct {
-	polling func(
-		messageChan, deletedMessageChan chan *Message,
-		errorChan chan error,
-		endChan chan bool,
-		userCacheClearChan chan interface{},
-	)
+	polling func(events Events)
 }
 
-func (w TimelineWorkerMock) Polling(
-	messageChan, deletedMessageChan chan *Message,
-	errorChan chan error,
-	endChan chan bool,
-	userCacheClearChan chan interface{},
-) {
-	w.polling(messageChan, deletedMessageChan, errorChan, endChan, userCacheClearChan)
+func (w TimelineWorkerMock) Polling(events Events) {
+	w.polling(events)
 }